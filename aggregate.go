@@ -0,0 +1,155 @@
+package cwatsch
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithAggregation makes the batch collapse the datums of the same metric into
+// one datum carrying StatisticValues (SampleCount, Sum, Minimum and Maximum)
+// instead of queueing each of them. Datums belong to the same metric if they
+// have the same name, dimensions and unit, and their timestamps fall into the
+// same period (a second for high resolution datums, a minute otherwise). This
+// reduces the number of datums, and thus requests, dramatically for metrics
+// reported many times per flush interval.
+//
+// Only datums carrying a Value or StatisticValues are aggregated. Metrics
+// declared with WithPercentiles never are, and WithoutAggregation switches the
+// aggregation off for individual namespaces.
+func WithAggregation() Option {
+	return func(b *Batch) {
+		b.aggregation = true
+	}
+}
+
+// WithoutAggregation switches the aggregation enabled with WithAggregation off
+// for the namespaces, so that they keep receiving raw data.
+func WithoutAggregation(namespaces ...string) Option {
+	return func(b *Batch) {
+		if b.rawNamespaces == nil {
+			b.rawNamespaces = map[string]bool{}
+		}
+
+		for _, ns := range namespaces {
+			b.rawNamespaces[ns] = true
+		}
+	}
+}
+
+// aggregate merges the datum into the aggregated datums of the queue. It
+// returns the datums that have to be queued: none if the datum has been merged
+// entirely, otherwise new aggregated datums that subsequent datums of the same
+// metric are merged into (or the datum itself if it isn't aggregated at all).
+// Must be called with the lock held.
+func (b *Batch) aggregate(ns string, q *queue, datum *cw.MetricDatum) []*cw.MetricDatum {
+	if datum == nil || b.rawNamespaces[ns] {
+		return []*cw.MetricDatum{datum}
+	}
+
+	switch {
+	case b.aggregation && !b.isPercentile(ns, datum) && len(datum.Values) == 0 &&
+		(datum.Value != nil || datum.StatisticValues != nil):
+		return b.aggregateStatistics(q, datum)
+	case b.valueArrays && datum.StatisticValues == nil && (datum.Value != nil || len(datum.Values) > 0):
+		return b.packValues(q, datum)
+	}
+
+	return []*cw.MetricDatum{datum}
+}
+
+// aggregated is a queued datum other datums are merged into.
+type aggregated struct {
+	datum *cw.MetricDatum
+	// index maps the values to their position in datum.Values, it's only
+	// used for value arrays
+	index map[float64]int
+}
+
+func (q *queue) setAggregated(key string, agg *aggregated) {
+	if q.aggregated == nil {
+		q.aggregated = map[string]*aggregated{}
+	}
+
+	q.aggregated[key] = agg
+}
+
+// forgetAggregated makes sure no datum is merged into d anymore, e.g. because d
+// has been discarded.
+func (q *queue) forgetAggregated(d *cw.MetricDatum) {
+	if d == nil || q.aggregated == nil {
+		return
+	}
+
+	key := aggregationKey(d)
+	if agg := q.aggregated[key]; agg != nil && agg.datum == d {
+		delete(q.aggregated, key)
+	}
+}
+
+func (b *Batch) aggregateStatistics(q *queue, datum *cw.MetricDatum) []*cw.MetricDatum {
+	key := aggregationKey(datum)
+
+	if agg := q.aggregated[key]; agg != nil {
+		mergeStatistics(agg.datum.StatisticValues, datum)
+		return nil
+	}
+
+	d := *datum
+	d.Value = nil
+	d.StatisticValues = &cw.StatisticSet{}
+	mergeStatistics(d.StatisticValues, datum)
+
+	q.setAggregated(key, &aggregated{datum: &d})
+
+	return []*cw.MetricDatum{&d}
+}
+
+// aggregationKey identifies the metric and the period the datum belongs to.
+func aggregationKey(d *cw.MetricDatum) string {
+	key := seriesKey(d) + "\x00" + aws.StringValue(d.Unit)
+
+	if d.Timestamp != nil {
+		period := time.Minute
+		if aws.Int64Value(d.StorageResolution) == 1 {
+			period = time.Second
+		}
+
+		key += "\x00" + strconv.FormatInt(d.Timestamp.Truncate(period).Unix(), 10)
+	}
+
+	return key
+}
+
+// mergeStatistics adds the value (or the statistics) of the datum to set.
+func mergeStatistics(set *cw.StatisticSet, d *cw.MetricDatum) {
+	count, sum, min, max := 1.0, 0.0, 0.0, 0.0
+
+	if d.Value != nil {
+		sum, min, max = *d.Value, *d.Value, *d.Value
+	} else {
+		s := d.StatisticValues
+		count, sum = aws.Float64Value(s.SampleCount), aws.Float64Value(s.Sum)
+		min, max = aws.Float64Value(s.Minimum), aws.Float64Value(s.Maximum)
+	}
+
+	if set.SampleCount == nil {
+		set.SampleCount, set.Sum = aws.Float64(count), aws.Float64(sum)
+		set.Minimum, set.Maximum = aws.Float64(min), aws.Float64(max)
+
+		return
+	}
+
+	*set.SampleCount += count
+	*set.Sum += sum
+
+	if min < *set.Minimum {
+		*set.Minimum = min
+	}
+
+	if max > *set.Maximum {
+		*set.Maximum = max
+	}
+}
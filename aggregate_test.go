@@ -0,0 +1,95 @@
+package cwatsch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregation(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithAggregation())
+
+	ts := time.Now().Add(-time.Hour).Truncate(time.Minute).Add(10 * time.Second)
+	latency := cwatsch.Datum("latency").Unit(cw.StandardUnitMilliseconds).Dim("endpoint", "/users")
+
+	for i := 1; i <= 100; i++ {
+		batch.Add("myApp", latency.Value(float64(i)).At(ts.Add(time.Duration(i%40)*time.Second)).Build())
+	}
+
+	batch.Add("myApp",
+		latency.Value(1).At(ts.Add(time.Minute)).Build(),
+		latency.Value(1).Unit(cw.StandardUnitSeconds).At(ts).Build(),
+		latency.Value(1).Dim("method", "GET").At(ts).Build(),
+		&cw.MetricDatum{
+			MetricName: aws.String("latency"),
+			Unit:       aws.String(cw.StandardUnitMilliseconds),
+			Dimensions: cwatsch.Dimensions(map[string]string{"endpoint": "/users"}),
+			Timestamp:  aws.Time(ts),
+			StatisticValues: &cw.StatisticSet{
+				SampleCount: aws.Float64(10), Sum: aws.Float64(5000), Minimum: aws.Float64(200), Maximum: aws.Float64(900),
+			},
+		},
+	)
+
+	assert.Equal(t, int64(4), batch.Stats().Pending)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 4)
+
+	assert.Nil(t, data[0].Value)
+	assert.Equal(t, &cw.StatisticSet{
+		SampleCount: aws.Float64(110),
+		Sum:         aws.Float64(5050 + 5000),
+		Minimum:     aws.Float64(1),
+		Maximum:     aws.Float64(900),
+	}, data[0].StatisticValues)
+
+	for _, d := range data[1:] {
+		assert.Equal(t, 1.0, aws.Float64Value(d.StatisticValues.SampleCount))
+	}
+}
+
+func TestAggregationCanBeDisabledPerNamespace(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithAggregation(), cwatsch.WithoutAggregation("raw"))
+
+	ts := time.Now()
+
+	for i := 0; i < 3; i++ {
+		batch.Add("raw", cwatsch.Datum("latency").Value(1).At(ts).Build())
+		batch.Add("aggregated", cwatsch.Datum("latency").Value(1).At(ts).Build())
+	}
+
+	require.NoError(t, batch.Flush())
+
+	sortByNS(cwAPI.capturedPayloads)
+	require.Len(t, cwAPI.capturedPayloads, 2)
+	assert.Len(t, cwAPI.capturedPayloads[0].MetricData, 1)
+	assert.Len(t, cwAPI.capturedPayloads[1].MetricData, 3)
+	assert.Equal(t, 1.0, aws.Float64Value(cwAPI.capturedPayloads[1].MetricData[0].Value))
+}
+
+func TestAggregationDoesNotTouchSentData(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithAggregation())
+
+	ts := time.Now()
+
+	batch.Add("myApp", cwatsch.Datum("latency").Value(1).At(ts).Build())
+	require.NoError(t, batch.Flush())
+
+	batch.Add("myApp", cwatsch.Datum("latency").Value(2).At(ts).Build())
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 2)
+	assert.Equal(t, 1.0, aws.Float64Value(cwAPI.capturedPayloads[0].MetricData[0].StatisticValues.Sum))
+	assert.Equal(t, 2.0, aws.Float64Value(cwAPI.capturedPayloads[1].MetricData[0].StatisticValues.Sum))
+}
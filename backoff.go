@@ -0,0 +1,88 @@
+package cwatsch
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// WithFailureBackoff makes the auto-flush (see LaunchAutoFlush) back off after
+// failed flushes: the interval doubles with every consecutive failure, up to
+// maxInterval. The first successful flush restores the configured interval.
+// This avoids piling up failing requests (and error logs) during an outage.
+func WithFailureBackoff(maxInterval time.Duration) Option {
+	return func(b *Batch) {
+		b.failureBackoff = maxInterval
+	}
+}
+
+// defaultFlushJitter is the jitter of the auto-flush interval used unless
+// WithFlushJitter says otherwise.
+const defaultFlushJitter = 0.1
+
+// WithFlushJitter randomizes every interval of the auto-flush (see
+// LaunchAutoFlush) by up to the given fraction of it in both directions, e.g.
+// 0.1 makes a one minute interval last between 54 and 66 seconds. This keeps
+// many instances started at the same time from flushing at the same moment
+// and spiking the request rate. The default is 0.1, 0 disables the jitter.
+func WithFlushJitter(fraction float64) Option {
+	return func(b *Batch) {
+		b.flushJitter = math.Min(math.Max(fraction, 0), 1)
+	}
+}
+
+// jittered returns the delay randomized according to WithFlushJitter.
+func (b *Batch) jittered(delay time.Duration) time.Duration {
+	spread := time.Duration(float64(delay) * b.flushJitter)
+	if spread <= 0 {
+		return delay
+	}
+
+	return delay - spread + time.Duration(rand.Int63n(int64(2*spread)+1))
+}
+
+func (b *Batch) autoFlush(ctx context.Context, interval time.Duration, onError func(error)) {
+	delay := interval
+
+	// a single timer reset after every flush, the delay varies with the
+	// backoff and the jitter so a ticker doesn't fit
+	timer := time.NewTimer(b.jittered(delay))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			// the metrics added since the last tick would be lost otherwise
+			if err := b.FlushWithTimeout(finalFlushTimeout); err != nil && onError != nil {
+				onError(err)
+			}
+
+			return
+		}
+
+		err := b.FlushCtx(ctx)
+		if onError != nil {
+			onError(err)
+		}
+
+		delay = b.nextFlushDelay(delay, interval, err)
+		timer.Reset(b.jittered(delay))
+	}
+}
+
+// nextFlushDelay returns the delay before the next auto-flush given the
+// current delay and the outcome of the last flush.
+func (b *Batch) nextFlushDelay(delay, interval time.Duration, err error) time.Duration {
+	if err == nil || b.failureBackoff <= interval {
+		return interval
+	}
+
+	delay *= 2
+	if delay > b.failureBackoff {
+		delay = b.failureBackoff
+	}
+
+	return delay
+}
@@ -0,0 +1,133 @@
+package cwatsch
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// DropPolicy defines what happens to a datum added to a full queue, see
+// WithMaxQueueSize.
+type DropPolicy int
+
+const (
+	// DropOldest discards the oldest datum of the queue to make room for the
+	// new one.
+	DropOldest DropPolicy = iota
+	// DropNewest discards the new datum.
+	DropNewest
+	// Block makes the caller wait until a flush makes room in the queue. An
+	// input is only blocked before it's queued, once it's admitted all its
+	// datums are queued, so the queue may exceed its size by one input. Use
+	// AddCtx to limit the wait.
+	Block
+)
+
+// WithMaxQueueSize limits the number of datums queued per namespace to n, so
+// that the buffer can't grow without bounds when flushing fails or falls behind
+// (e.g. during a CloudWatch outage). policy defines what happens to the datums
+// added to a full queue. The discarded datums are counted in Stats.Dropped and
+// Stats.Overflowed.
+func WithMaxQueueSize(n int, policy DropPolicy) Option {
+	return func(b *Batch) {
+		b.maxQueueSize = n
+		b.dropPolicy = policy
+		b.space = sync.NewCond(&b.Mutex)
+	}
+}
+
+// AddCtx adds the datums the same way Add does. It only differs from Add if
+// the batch blocks on full queues (see WithMaxQueueSize): the wait is
+// abandoned once ctx is done, the datums are not added then and the context's
+// error is returned.
+func (b *Batch) AddCtx(ctx context.Context, namespace string, data ...*cw.MetricDatum) error {
+	err := b.add(ctx, &cw.PutMetricDataInput{
+		Namespace:  aws.String(namespace),
+		MetricData: data,
+	})
+	b.checkGlobalThreshold()
+
+	return err
+}
+
+// waitForSpace blocks until the queue of the namespace isn't full anymore or
+// ctx is done. Must be called with the lock held.
+func (b *Batch) waitForSpace(ctx context.Context, ns string) error {
+	if b.dropPolicy != Block || b.maxQueueSize <= 0 {
+		return nil
+	}
+
+	full := func() bool {
+		q, ok := b.metricQs[ns]
+		return ok && q.count >= b.maxQueueSize
+	}
+
+	if !full() {
+		return nil
+	}
+
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		go func() {
+			select {
+			case <-done:
+				b.Lock()
+				b.space.Broadcast()
+				b.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+
+	for full() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		b.space.Wait()
+	}
+
+	return nil
+}
+
+// madeSpace wakes up the callers waiting for space in the queues. Must be
+// called with the lock held.
+func (b *Batch) madeSpace() {
+	if b.space != nil {
+		b.space.Broadcast()
+	}
+}
+
+// admit makes room in the queue for one more datum. It returns false if the
+// datum has to be discarded instead. Must be called with the lock held.
+func (b *Batch) admit(q *queue) bool {
+	if b.maxQueueSize <= 0 || q.count < b.maxQueueSize {
+		return true
+	}
+
+	switch b.dropPolicy {
+	case DropOldest:
+		// the oldest datum is discarded rather than sent, so the other datums
+		// can still be merged into
+		aggregated := q.aggregated
+		dropped := q.pop()
+		q.aggregated = aggregated
+		q.forgetAggregated(dropped)
+
+		// the pending counter is adjusted by the caller
+		b.forgetAttempt(dropped)
+	case DropNewest:
+	default:
+		return true
+	}
+
+	atomic.AddInt64(&b.counters.dropped, 1)
+	atomic.AddInt64(&b.counters.overflowed, 1)
+
+	return b.dropPolicy == DropOldest
+}
@@ -0,0 +1,137 @@
+package cwatsch_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sentNames(cwAPI *cwMock) []string {
+	names := []string{}
+
+	for _, p := range cwAPI.payloads() {
+		for _, d := range p.MetricData {
+			names = append(names, aws.StringValue(d.MetricName))
+		}
+	}
+
+	return names
+}
+
+func TestMaxQueueSizeDropOldest(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxQueueSize(3, cwatsch.DropOldest))
+
+	batch.Add("myApp", metricData("metric", 5)...)
+	batch.Add("other", metricData("metric", 1)...)
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(4), stats.Pending)
+	assert.Equal(t, int64(2), stats.Dropped)
+	assert.Equal(t, int64(2), stats.Overflowed)
+
+	require.NoError(t, batch.Flush())
+	assert.ElementsMatch(t, []string{"metric2", "metric3", "metric4", "metric0"}, sentNames(&cwAPI))
+}
+
+func TestMaxQueueSizeDropNewest(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxQueueSize(3, cwatsch.DropNewest))
+
+	batch.Add("myApp", metricData("metric", 5)...)
+
+	assert.Equal(t, int64(3), batch.Stats().Pending)
+	assert.Equal(t, int64(2), batch.Stats().Overflowed)
+
+	require.NoError(t, batch.Flush())
+	assert.Equal(t, []string{"metric0", "metric1", "metric2"}, sentNames(&cwAPI))
+}
+
+func TestMaxQueueSizeBlock(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxQueueSize(3, cwatsch.Block))
+
+	batch.Add("myApp", metricData("metric", 3)...)
+
+	added := make(chan error)
+
+	go func() {
+		added <- batch.AddCtx(context.Background(), "myApp", metricData("later", 1)...)
+	}()
+
+	select {
+	case <-added:
+		t.Fatal("adding to a full queue must block")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	require.NoError(t, batch.Flush())
+	require.NoError(t, <-added)
+	assert.Equal(t, int64(1), batch.Stats().Pending)
+	assert.Equal(t, int64(0), batch.Stats().Dropped)
+
+	batch.Add("myApp", metricData("metric", 2)...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, batch.AddCtx(ctx, "myApp", metricData("dropped", 1)...))
+	assert.Equal(t, int64(3), batch.Stats().Pending)
+}
+
+func TestAddCtxOnlyWaitsForFullQueues(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxQueueSize(3, cwatsch.Block))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.NoError(t, batch.AddCtx(ctx, "myApp", metricData("metric", 3)...))
+	assert.Equal(t, context.Canceled, batch.AddCtx(ctx, "myApp", metricData("blocked", 1)...))
+	assert.NoError(t, batch.AddCtx(ctx, "other", metricData("metric", 1)...), "other namespaces aren't affected")
+
+	assert.Equal(t, int64(4), batch.Stats().Pending)
+}
+
+func TestMaxQueueSizeWithAggregation(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithAggregation(), cwatsch.WithMaxQueueSize(1, cwatsch.DropNewest))
+
+	batch.Add("myApp",
+		cwatsch.Datum("a").Value(1).Build(),
+		cwatsch.Datum("b").Value(1).Build(),
+		cwatsch.Datum("b").Value(2).Build(),
+		cwatsch.Datum("b").Value(3).Build(),
+	)
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(1), stats.Pending)
+	assert.Equal(t, int64(3), stats.Dropped, "the datums aren't merged into the discarded one")
+	assert.Equal(t, int64(3), stats.Overflowed)
+
+	require.NoError(t, batch.Flush())
+	assert.Equal(t, []string{"a"}, sentNames(&cwAPI))
+
+	cwAPI = cwMock{}
+	batch = cwatsch.New(&cwAPI, cwatsch.WithAggregation(), cwatsch.WithMaxQueueSize(1, cwatsch.DropOldest))
+
+	batch.Add("myApp",
+		cwatsch.Datum("a").Value(1).Build(),
+		cwatsch.Datum("b").Value(1).Build(),
+		cwatsch.Datum("b").Value(2).Build(),
+	)
+
+	stats = batch.Stats()
+	assert.Equal(t, int64(1), stats.Pending)
+	assert.Equal(t, int64(1), stats.Dropped, "the aggregation goes on once the queue is full")
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	require.Len(t, cwAPI.capturedPayloads[0].MetricData, 1)
+	assert.Equal(t, 2.0, aws.Float64Value(cwAPI.capturedPayloads[0].MetricData[0].StatisticValues.SampleCount))
+}
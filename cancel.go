@@ -0,0 +1,46 @@
+package cwatsch
+
+import (
+	"context"
+	"time"
+)
+
+// WithPreserveOnCancelledContext makes a flush that is given an already
+// cancelled context return the context's error right away, leaving all the
+// metrics buffered for a later flush instead of taking them out of the buffer
+// for requests that are doomed to fail.
+func WithPreserveOnCancelledContext() Option {
+	return func(b *Batch) {
+		b.preserveOnCancelled = true
+	}
+}
+
+// WithFlushOnCancelledContext makes a flush that is given an already cancelled
+// context proceed with a fresh context limited by timeout instead. It's meant
+// for shutdown paths where the caller's context is gone by the time the final
+// flush runs. It takes precedence over WithPreserveOnCancelledContext.
+func WithFlushOnCancelledContext(timeout time.Duration) Option {
+	return func(b *Batch) {
+		b.cancelledFlushTimeout = timeout
+	}
+}
+
+// flushContext returns the context a flush should run with, or an error if
+// the flush must not run at all.
+func (b *Batch) flushContext(ctx context.Context) (context.Context, context.CancelFunc, error) {
+	if ctx.Err() == nil {
+		return ctx, func() {}, nil
+	}
+
+	if b.cancelledFlushTimeout <= 0 {
+		if b.preserveOnCancelled {
+			return nil, nil, ctx.Err()
+		}
+
+		return ctx, func() {}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.cancelledFlushTimeout)
+
+	return ctx, cancel, nil
+}
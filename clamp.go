@@ -0,0 +1,90 @@
+package cwatsch
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithValueClamp limits the values of the metric name to the range
+// [min, max]. Values outside of the range are replaced by the nearest bound.
+// It protects dashboards and alarms from implausible values, e.g. a broken
+// timer reporting billions of milliseconds. Clamped datums are counted as
+// modifications (see WithInternalMetrics), so that the source of such values
+// can be tracked down.
+//
+// The clamp applies to Value and Values of the datums, statistic sets are
+// left as they are.
+func WithValueClamp(name string, min, max float64) Option {
+	return func(b *Batch) {
+		if b.clamps == nil {
+			b.clamps = map[string]valueRange{}
+		}
+
+		b.clamps[name] = valueRange{min: min, max: max}
+	}
+}
+
+type valueRange struct {
+	min, max float64
+}
+
+func (r valueRange) clamp(v float64) float64 {
+	if v < r.min {
+		return r.min
+	}
+
+	if v > r.max {
+		return r.max
+	}
+
+	return v
+}
+
+// clampValues returns the datum with its values clamped to the range
+// configured for the metric. edit must be used to get a datum that can be
+// altered.
+func (b *Batch) clampValues(datum *cw.MetricDatum, edit func() *cw.MetricDatum) *cw.MetricDatum {
+	r, ok := b.clamps[aws.StringValue(datum.MetricName)]
+	if !ok {
+		return datum
+	}
+
+	clamped := false
+
+	if datum.Value != nil {
+		if v := r.clamp(*datum.Value); v != *datum.Value {
+			edit().Value = aws.Float64(v)
+			clamped = true
+		}
+	}
+
+	var values []*float64
+
+	for i, value := range datum.Values {
+		if value == nil {
+			continue
+		}
+
+		if v := r.clamp(*value); v != *value {
+			if values == nil {
+				// the slice is shared with the caller's datum, it's copied
+				values = append([]*float64(nil), datum.Values...)
+			}
+
+			values[i] = aws.Float64(v)
+		}
+	}
+
+	if values != nil {
+		edit().Values = values
+		clamped = true
+	}
+
+	if !clamped {
+		return datum
+	}
+
+	b.modified("ValueClamped")
+
+	return edit()
+}
@@ -0,0 +1,38 @@
+package cwatsch_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueClamp(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithValueClamp("latency", 0, 60000))
+
+	values := aws.Float64Slice([]float64{5, 3e9})
+	batch.Add("myApp",
+		&cw.MetricDatum{MetricName: aws.String("latency"), Value: aws.Float64(4e9)},
+		&cw.MetricDatum{MetricName: aws.String("latency"), Value: aws.Float64(-3)},
+		&cw.MetricDatum{MetricName: aws.String("latency"), Value: aws.Float64(120)},
+		&cw.MetricDatum{MetricName: aws.String("latency"), Values: values},
+		&cw.MetricDatum{MetricName: aws.String("bytes"), Value: aws.Float64(4e9)},
+	)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+
+	assert.Equal(t, 60000.0, aws.Float64Value(data[0].Value))
+	assert.Equal(t, 0.0, aws.Float64Value(data[1].Value))
+	assert.Equal(t, 120.0, aws.Float64Value(data[2].Value))
+	assert.Equal(t, []float64{5, 60000}, aws.Float64ValueSlice(data[3].Values))
+	assert.Equal(t, 4e9, aws.Float64Value(data[4].Value))
+
+	assert.Equal(t, []float64{5, 3e9}, aws.Float64ValueSlice(values), "caller's values are not modified")
+	assert.Equal(t, int64(3), batch.Stats().Modified)
+}
@@ -0,0 +1,100 @@
+package cwatsch
+
+import (
+	"sync/atomic"
+
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithCompaction makes every flush compact the queues before sending them: the
+// datums of the same metric (see WithAggregation) queued since the last flush
+// are merged into one datum carrying StatisticValues. Unlike WithAggregation,
+// which merges the datums as they are added, the compaction costs nothing when
+// adding and a single pass over the buffer when flushing, and it merges the
+// datums kept from failed or cancelled flushes as well. CloudWatch bills per
+// datum, so fewer datums mean both fewer requests and a lower bill for metrics
+// reported many times per flush interval.
+//
+// The same datums as with WithAggregation are left alone: the ones without a
+// Value or StatisticValues, the ones carrying value arrays, the metrics
+// declared with WithPercentiles and the namespaces passed to
+// WithoutAggregation. Queues preserving the input batches (see
+// WithPreserveInputBatches) aren't compacted either.
+func WithCompaction() Option {
+	return func(b *Batch) {
+		b.compaction = true
+	}
+}
+
+// compact merges the datums of the same metric in the queue, keeping the order
+// of the first datum of every metric. The queue must not be accessed
+// concurrently.
+func (b *Batch) compact(ns string, q *queue) {
+	if !b.compaction || q.grouped || q.count < 2 || b.rawNamespaces[ns] {
+		return
+	}
+
+	// keys holds the aggregation keys in the order of the queue, empty for the
+	// datums that aren't compacted
+	keys := make([]string, 0, q.count)
+	counts := map[string]int{}
+	duplicates := false
+
+	q.each(func(d *cw.MetricDatum) {
+		key := ""
+		if b.compactable(ns, d) {
+			key = aggregationKey(d)
+			counts[key]++
+			duplicates = duplicates || counts[key] > 1
+		}
+
+		keys = append(keys, key)
+	})
+
+	if !duplicates {
+		return
+	}
+
+	count := q.count
+	merged := map[string]*cw.MetricDatum{}
+	data := make([]*cw.MetricDatum, 0, q.count)
+
+	for _, key := range keys {
+		d := q.pop()
+
+		if counts[key] < 2 {
+			data = append(data, d)
+			continue
+		}
+
+		// the merged datums are gone, their failed attempts (see
+		// WithRequeueOnError) with them
+		b.forgetAttempt(d)
+
+		if into := merged[key]; into != nil {
+			mergeStatistics(into.StatisticValues, d)
+			continue
+		}
+
+		// the queued datum may be the caller's, it's copied before merging
+		// the others into it
+		c := *d
+		c.Value = nil
+		c.StatisticValues = &cw.StatisticSet{}
+		mergeStatistics(c.StatisticValues, d)
+
+		merged[key] = &c
+		data = append(data, &c)
+	}
+
+	for _, d := range data {
+		q.push(d)
+	}
+
+	atomic.AddInt64(&b.counters.pending, -int64(count-q.count))
+}
+
+func (b *Batch) compactable(ns string, d *cw.MetricDatum) bool {
+	return d != nil && len(d.Values) == 0 && (d.Value != nil || d.StatisticValues != nil) &&
+		!b.isPercentile(ns, d)
+}
@@ -0,0 +1,130 @@
+package cwatsch_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompaction(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithCompaction(), cwatsch.WithPercentiles("myApp", "p"))
+
+	ts := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	latency := cwatsch.Datum("latency").Unit(cw.StandardUnitMilliseconds).Dim("endpoint", "/users").At(ts)
+	first := latency.Value(1).Build()
+
+	batch.Add("myApp", first, cwatsch.Datum("once").Value(7).At(ts).Build())
+
+	for i := 2; i <= 50; i++ {
+		batch.Add("myApp", latency.Value(float64(i)).At(ts.Add(time.Duration(i)*time.Second)).Build())
+	}
+
+	batch.Add("myApp",
+		latency.Value(1).At(ts.Add(time.Minute)).Build(),
+		cwatsch.Datum("p").Value(1).At(ts).Build(),
+		cwatsch.Datum("p").Value(2).At(ts).Build(),
+		&cw.MetricDatum{MetricName: aws.String("arr"), Values: aws.Float64Slice([]float64{1, 2}), Timestamp: aws.Time(ts)},
+		&cw.MetricDatum{MetricName: aws.String("arr"), Values: aws.Float64Slice([]float64{3}), Timestamp: aws.Time(ts)},
+		&cw.MetricDatum{
+			MetricName: aws.String("latency"),
+			Unit:       aws.String(cw.StandardUnitMilliseconds),
+			Dimensions: cwatsch.Dimensions(map[string]string{"endpoint": "/users"}),
+			Timestamp:  aws.Time(ts),
+			StatisticValues: &cw.StatisticSet{
+				SampleCount: aws.Float64(10), Sum: aws.Float64(5000), Minimum: aws.Float64(200), Maximum: aws.Float64(900),
+			},
+		},
+	)
+
+	assert.Equal(t, int64(57), batch.Stats().Pending, "datums are compacted on flush only")
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 7)
+
+	assert.Equal(t, &cw.StatisticSet{
+		SampleCount: aws.Float64(60),
+		Sum:         aws.Float64(1275 + 5000),
+		Minimum:     aws.Float64(1),
+		Maximum:     aws.Float64(900),
+	}, data[0].StatisticValues)
+	assert.Nil(t, data[0].Value)
+
+	assert.Equal(t, 7.0, aws.Float64Value(data[1].Value), "a single datum is left as it is")
+	assert.Equal(t, 1.0, aws.Float64Value(data[2].Value), "the next minute is a separate datum")
+
+	for i, name := range []string{"p", "p", "arr", "arr"} {
+		assert.Equal(t, name, aws.StringValue(data[3+i].MetricName))
+		assert.Nil(t, data[3+i].StatisticValues)
+	}
+
+	assert.Equal(t, 1.0, aws.Float64Value(first.Value), "caller's datum is not modified")
+	assert.Nil(t, first.StatisticValues)
+	assert.Equal(t, int64(0), batch.Stats().Pending)
+}
+
+func TestCompactionSkipsRawNamespaces(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithCompaction(), cwatsch.WithoutAggregation("raw"))
+
+	for i := 0; i < 3; i++ {
+		batch.Add("raw", cwatsch.Datum("m").Value(1).Build())
+	}
+
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Len(t, cwAPI.capturedPayloads[0].MetricData, 3)
+}
+
+func BenchmarkCompaction(b *testing.B) {
+	for _, opts := range []struct {
+		name string
+		opts []cwatsch.Option
+	}{
+		{"off", nil},
+		{"on", []cwatsch.Option{cwatsch.WithCompaction()}},
+	} {
+		b.Run(opts.name, func(b *testing.B) {
+			batch := cwatsch.New(nil, opts.opts...)
+
+			var requests, datums int64
+
+			send := func(_ context.Context, input *cw.PutMetricDataInput) error {
+				atomic.AddInt64(&requests, 1)
+				atomic.AddInt64(&datums, int64(len(input.MetricData)))
+
+				return nil
+			}
+
+			ts := time.Now().Truncate(time.Minute)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				// 1000 datums of 10 metrics reported within one flush interval
+				for j := 0; j < 1000; j++ {
+					batch.Add("myApp", cwatsch.Datum(fmt.Sprintf("metric%d", j%10)).Value(float64(j)).At(ts).Build())
+				}
+
+				if err := batch.FlushTo(context.Background(), send); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ReportMetric(float64(requests)/float64(b.N), "requests/op")
+			b.ReportMetric(float64(datums)/float64(b.N), "datums/op")
+		})
+	}
+}
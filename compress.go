@@ -0,0 +1,71 @@
+package cwatsch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// WithCompression gzip-compresses the bodies of PutMetricData requests, which
+// reduces the data transfer for large batches. If CloudWatch refuses the
+// compressed payload, the request is repeated uncompressed and compression is
+// switched off for the rest of the batch's lifetime.
+//
+// Compression only has an effect if the client passed to New is the client
+// from the aws sdk (or wraps it and respects request options).
+func WithCompression() Option {
+	return func(b *Batch) {
+		b.compress = 1
+	}
+}
+
+func gzipRequest(r *request.Request) {
+	r.Handlers.Build.PushBackNamed(gzipBodyHandler)
+}
+
+// gzipBodyHandler must run after the protocol handler has built the body and
+// before the request is signed.
+var gzipBodyHandler = request.NamedHandler{
+	Name: "cwatsch.GzipBody",
+	Fn: func(r *request.Request) {
+		if r.Error != nil || r.Body == nil {
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			r.Error = awserr.New(request.ErrCodeSerialization, "failed to read request body", err)
+			return
+		}
+
+		var buf bytes.Buffer
+
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(body); err == nil {
+			err = zw.Close()
+		}
+
+		if err != nil {
+			r.Error = awserr.New(request.ErrCodeSerialization, "failed to gzip request body", err)
+			return
+		}
+
+		r.SetBufferBody(buf.Bytes())
+		r.HTTPRequest.Header.Set("Content-Encoding", "gzip")
+	},
+}
+
+func isCompressionRejected(err error) bool {
+	var reqErr awserr.RequestFailure
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+
+	return reqErr.StatusCode() == http.StatusUnsupportedMediaType ||
+		reqErr.Code() == "UnsupportedMediaType"
+}
@@ -0,0 +1,117 @@
+package cwatsch_test
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const putMetricDataResponse = `<PutMetricDataResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/">
+  <ResponseMetadata><RequestId>test</RequestId></ResponseMetadata>
+</PutMetricDataResponse>`
+
+const unsupportedMediaTypeResponse = `<ErrorResponse xmlns="http://monitoring.amazonaws.com/doc/2010-08-01/">
+  <Error><Type>Sender</Type><Code>UnsupportedMediaType</Code><Message>gzip not supported</Message></Error>
+  <RequestId>test</RequestId>
+</ErrorResponse>`
+
+type cwServer struct {
+	sync.Mutex
+	acceptGzip bool
+	encodings  []string
+	forms      []url.Values
+}
+
+func (s *cwServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	encoding := r.Header.Get("Content-Encoding")
+	s.encodings = append(s.encodings, encoding)
+
+	var body io.Reader = r.Body
+
+	if encoding == "gzip" {
+		if !s.acceptGzip {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			fmt.Fprint(w, unsupportedMediaTypeResponse)
+
+			return
+		}
+
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+
+	raw, _ := ioutil.ReadAll(body)
+	form, _ := url.ParseQuery(string(raw))
+	s.forms = append(s.forms, form)
+
+	fmt.Fprint(w, putMetricDataResponse)
+}
+
+func newCWClient(t *testing.T, srv *httptest.Server) *cw.CloudWatch {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(srv.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	require.NoError(t, err)
+
+	return cw.New(sess)
+}
+
+func TestCompression(t *testing.T) {
+	server := &cwServer{acceptGzip: true}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	batch := cwatsch.New(newCWClient(t, srv), cwatsch.WithCompression())
+	batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)})
+
+	require.NoError(t, batch.Flush())
+
+	assert.Equal(t, []string{"gzip"}, server.encodings)
+	require.Len(t, server.forms, 1)
+	assert.Equal(t, "PutMetricData", server.forms[0].Get("Action"))
+	assert.Equal(t, "myApp", server.forms[0].Get("Namespace"))
+	assert.Equal(t, "calls", server.forms[0].Get("MetricData.member.1.MetricName"))
+}
+
+func TestCompressionFallsBackWhenRejected(t *testing.T) {
+	server := &cwServer{acceptGzip: false}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	batch := cwatsch.New(newCWClient(t, srv), cwatsch.WithCompression())
+
+	batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)})
+	require.NoError(t, batch.Flush())
+
+	batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(2)})
+	require.NoError(t, batch.Flush())
+
+	assert.Equal(t, []string{"gzip", "", ""}, server.encodings)
+	require.Len(t, server.forms, 2)
+	assert.Equal(t, "1", server.forms[0].Get("MetricData.member.1.Value"))
+	assert.Equal(t, "2", server.forms[1].Get("MetricData.member.1.Value"))
+}
@@ -0,0 +1,51 @@
+package cwatsch
+
+import cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+
+// ConflictPolicy defines what happens to a datum having both Value and
+// StatisticValues set. CloudWatch rejects such datums, and with them the whole
+// request they are sent in.
+type ConflictPolicy int
+
+const (
+	// PreferStatisticValues keeps StatisticValues and clears Value. It's the
+	// default policy.
+	PreferStatisticValues ConflictPolicy = iota
+	// PreferValue keeps Value and clears StatisticValues.
+	PreferValue
+	// RejectConflicts drops the datum and reports a *DatumError via
+	// WithOnError.
+	RejectConflicts
+)
+
+// WithConflictPolicy sets how the datums having both Value and StatisticValues
+// set are handled. The resolved conflicts are counted as modifications (see
+// WithInternalMetrics).
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(b *Batch) {
+		b.conflictPolicy = policy
+	}
+}
+
+// resolveConflict returns the datum with at most one of Value and
+// StatisticValues set. edit must be used to get a datum that can be altered.
+func (b *Batch) resolveConflict(
+	ns string, datum *cw.MetricDatum, edit func() *cw.MetricDatum,
+) (*cw.MetricDatum, error) {
+	if datum.Value == nil || datum.StatisticValues == nil {
+		return datum, nil
+	}
+
+	switch b.conflictPolicy {
+	case PreferValue:
+		edit().StatisticValues = nil
+	case RejectConflicts:
+		return nil, &DatumError{Namespace: ns, Datum: datum, Reason: "both Value and StatisticValues are set"}
+	default:
+		edit().Value = nil
+	}
+
+	b.modified("ValueConflictResolved")
+
+	return edit(), nil
+}
@@ -0,0 +1,77 @@
+package cwatsch_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func conflictingDatum() *cw.MetricDatum {
+	return &cw.MetricDatum{
+		MetricName: aws.String("latency"),
+		Value:      aws.Float64(5),
+		StatisticValues: &cw.StatisticSet{
+			SampleCount: aws.Float64(2),
+			Sum:         aws.Float64(3),
+			Minimum:     aws.Float64(1),
+			Maximum:     aws.Float64(2),
+		},
+	}
+}
+
+func TestConflictPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		opts          []cwatsch.Option
+		expectedValue *float64
+		expectedStats bool
+	}{
+		{name: "default", expectedStats: true},
+		{name: "prefer statistic values", opts: []cwatsch.Option{cwatsch.WithConflictPolicy(cwatsch.PreferStatisticValues)}, expectedStats: true},
+		{name: "prefer value", opts: []cwatsch.Option{cwatsch.WithConflictPolicy(cwatsch.PreferValue)}, expectedValue: aws.Float64(5)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cwAPI := cwMock{}
+			batch := cwatsch.New(&cwAPI, tc.opts...)
+
+			datum := conflictingDatum()
+			batch.Add("myApp", datum)
+			require.NoError(t, batch.Flush())
+
+			require.Len(t, cwAPI.capturedPayloads, 1)
+			sent := cwAPI.capturedPayloads[0].MetricData[0]
+
+			assert.Equal(t, tc.expectedValue, sent.Value)
+			assert.Equal(t, tc.expectedStats, sent.StatisticValues != nil)
+			assert.Equal(t, conflictingDatum(), datum, "caller's datum is not modified")
+			assert.Equal(t, int64(1), batch.Stats().Modified)
+		})
+	}
+}
+
+func TestConflictPolicyReject(t *testing.T) {
+	cwAPI := cwMock{}
+
+	var errs []error
+
+	batch := cwatsch.New(&cwAPI,
+		cwatsch.WithConflictPolicy(cwatsch.RejectConflicts),
+		cwatsch.WithOnError(func(err error) { errs = append(errs, err) }),
+	)
+
+	batch.Add("myApp", conflictingDatum(), &cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)})
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Equal(t, []*cw.MetricDatum{
+		{MetricName: aws.String("calls"), Value: aws.Float64(1)},
+	}, cwAPI.capturedPayloads[0].MetricData)
+
+	require.Len(t, errs, 1)
+	assert.IsType(t, &cwatsch.DatumError{}, errs[0])
+	assert.Equal(t, int64(1), batch.Stats().Dropped)
+}
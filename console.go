@@ -0,0 +1,121 @@
+package cwatsch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// ConsoleSink is a CloudWatch client printing the metrics as a human readable
+// table instead of sending them. It's meant for local development: pass it to
+// New in place of the real client to eyeball the metrics an application
+// emits. Every request is printed as one table with aligned columns. The
+// header is highlighted if the writer is a terminal.
+//
+// Only PutMetricData and PutMetricDataWithContext are implemented, calling any
+// other method of the sink panics.
+type ConsoleSink struct {
+	cloudwatchiface.CloudWatchAPI
+
+	mu    sync.Mutex
+	w     io.Writer
+	color bool
+}
+
+// NewConsoleSink creates a sink printing to w.
+func NewConsoleSink(w io.Writer) *ConsoleSink {
+	return &ConsoleSink{w: w, color: isTerminal(w)}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	fi, err := f.Stat()
+
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (s *ConsoleSink) PutMetricData(input *cw.PutMetricDataInput) (*cw.PutMetricDataOutput, error) {
+	return s.PutMetricDataWithContext(aws.BackgroundContext(), input)
+}
+
+func (s *ConsoleSink) PutMetricDataWithContext(
+	_ aws.Context, input *cw.PutMetricDataInput, _ ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "NAMESPACE\tNAME\tVALUE\tUNIT\tDIMENSIONS")
+
+	ns := aws.StringValue(input.Namespace)
+
+	for _, d := range input.MetricData {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			ns, aws.StringValue(d.MetricName), consoleValue(d), aws.StringValue(d.Unit), consoleDims(d.Dimensions))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return nil, err
+	}
+
+	table := buf.Bytes()
+
+	if s.color {
+		// the header is highlighted once aligned, the escape codes would
+		// otherwise count into the width of the columns
+		i := bytes.IndexByte(table, '\n')
+		table = append([]byte("\x1b[1m"+string(table[:i])+"\x1b[0m"), table[i:]...)
+	}
+
+	if _, err := s.w.Write(table); err != nil {
+		return nil, err
+	}
+
+	return &cw.PutMetricDataOutput{}, nil
+}
+
+func consoleValue(d *cw.MetricDatum) string {
+	switch {
+	case d.Value != nil:
+		return strconv.FormatFloat(*d.Value, 'g', -1, 64)
+	case d.StatisticValues != nil:
+		s := d.StatisticValues
+
+		return fmt.Sprintf("n=%g sum=%g min=%g max=%g",
+			aws.Float64Value(s.SampleCount), aws.Float64Value(s.Sum),
+			aws.Float64Value(s.Minimum), aws.Float64Value(s.Maximum))
+	case len(d.Values) > 0:
+		return fmt.Sprintf("%d values", len(d.Values))
+	}
+
+	return "-"
+}
+
+func consoleDims(dims []*cw.Dimension) string {
+	parts := make([]string, 0, len(dims))
+
+	for _, d := range dims {
+		if d != nil {
+			parts = append(parts, aws.StringValue(d.Name)+"="+aws.StringValue(d.Value))
+		}
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,30 @@
+package cwatsch_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConsoleSink(t *testing.T) {
+	var buf bytes.Buffer
+
+	batch := cwatsch.New(cwatsch.NewConsoleSink(&buf))
+	batch.Add("myApp",
+		cwatsch.Datum("latency").Value(12.5).Unit(cw.StandardUnitMilliseconds).Dim("endpoint", "/users").Build(),
+		&cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)},
+	)
+
+	require.NoError(t, batch.Flush())
+
+	assert.Equal(t, ""+
+		"NAMESPACE  NAME     VALUE  UNIT          DIMENSIONS\n"+
+		"myApp      latency  12.5   Milliseconds  endpoint=/users\n"+
+		"myApp      calls    1                    \n",
+		buf.String())
+}
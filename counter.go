@@ -0,0 +1,132 @@
+package cwatsch
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Counter is a monotonic counter owned by a batch. The accumulated count is
+// added to the batch as one datum (with unit Count) on every flush of all the
+// collected metrics, and the counter starts over from zero. Nothing is sent
+// for a counter that hasn't been incremented since the last flush.
+//
+// Counters are safe for concurrent use and don't take any lock, so that they
+// can be incremented from many goroutines at once.
+type Counter struct {
+	n int64
+
+	namespace string
+	name      string
+	dims      []*cw.Dimension
+	// events makes the count be sent as a statistic set of n events of value
+	// 1, see Incr.
+	events bool
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n int64) {
+	atomic.AddInt64(&c.n, n)
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddInt64(&c.n, 1)
+}
+
+// Counter returns the counter of the metric, creating it on first use. The
+// order of the dimensions doesn't matter. Looking up an existing counter
+// doesn't take any lock, yet callers on hot paths may want to keep the
+// returned counter instead of looking it up on every increment.
+//
+// Counters live as long as the batch does, they are meant for a bounded set
+// of metrics.
+func (b *Batch) Counter(namespace, name string, dims ...*cw.Dimension) *Counter {
+	return b.counter(false, namespace, name, dims)
+}
+
+// Incr counts an event of the metric. The events counted since the last flush
+// of all the collected metrics are sent as one datum carrying a statistic set
+// with SampleCount (and Sum) equal to the number of events, instead of one
+// datum per event. Run LaunchAutoFlush to have them sent periodically. The
+// counting state is kept the same way it is for Counter.
+func (b *Batch) Incr(namespace, name string, dims ...*cw.Dimension) {
+	b.counter(true, namespace, name, dims).Inc()
+}
+
+func (b *Batch) counter(events bool, namespace, name string, dims []*cw.Dimension) *Counter {
+	key := namespace + "\x00" + seriesKey(&cw.MetricDatum{MetricName: &name, Dimensions: dims})
+	if events {
+		key = "\x01" + key
+	}
+
+	if c, ok := b.counterSet.Load(key); ok {
+		return c.(*Counter)
+	}
+
+	c, _ := b.counterSet.LoadOrStore(key, &Counter{
+		namespace: namespace,
+		name:      name,
+		dims:      append([]*cw.Dimension(nil), dims...),
+		events:    events,
+	})
+
+	return c.(*Counter)
+}
+
+// collectCounters adds the counts accumulated since the last collection to the
+// buffer and returns the errors of the rejected datums. Must be called with the
+// lock held.
+func (b *Batch) collectCounters() []error {
+	now := time.Now()
+	data := map[string][]*cw.MetricDatum{}
+
+	b.counterSet.Range(func(_, v interface{}) bool {
+		c := v.(*Counter)
+
+		n := atomic.SwapInt64(&c.n, 0)
+		if n == 0 {
+			return true
+		}
+
+		datum := &cw.MetricDatum{
+			MetricName: aws.String(c.name),
+			Dimensions: c.dims,
+			Unit:       aws.String(cw.StandardUnitCount),
+			Timestamp:  aws.Time(now),
+		}
+
+		if c.events {
+			datum.StatisticValues = &cw.StatisticSet{
+				SampleCount: aws.Float64(float64(n)),
+				Sum:         aws.Float64(float64(n)),
+				Minimum:     aws.Float64(1),
+				Maximum:     aws.Float64(1),
+			}
+		} else {
+			datum.Value = aws.Float64(float64(n))
+		}
+
+		data[c.namespace] = append(data[c.namespace], datum)
+
+		return true
+	})
+
+	namespaces := make([]string, 0, len(data))
+	for ns := range data {
+		namespaces = append(namespaces, ns)
+	}
+
+	sort.Strings(namespaces)
+
+	var errs []error
+
+	for _, ns := range namespaces {
+		errs = append(errs, b.addLocked(&cw.PutMetricDataInput{Namespace: aws.String(ns), MetricData: data[ns]})...)
+	}
+
+	return errs
+}
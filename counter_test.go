@@ -0,0 +1,118 @@
+package cwatsch_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounter(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	dims := cwatsch.Dimensions(map[string]string{"method": "GET", "endpoint": "/users"})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				batch.Counter("myApp", "requests", dims...).Inc()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	reversed := []*cw.Dimension{dims[1], dims[0]}
+	batch.Counter("myApp", "requests", reversed...).Add(5)
+	batch.Counter("myApp", "idle")
+
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 1, "counters that weren't incremented aren't sent")
+	assert.Equal(t, "requests", aws.StringValue(data[0].MetricName))
+	assert.Equal(t, dims, data[0].Dimensions)
+	assert.Equal(t, 5005.0, aws.Float64Value(data[0].Value))
+	assert.Equal(t, cw.StandardUnitCount, aws.StringValue(data[0].Unit))
+
+	cwAPI.capturedPayloads = nil
+	require.NoError(t, batch.Flush())
+	assert.Empty(t, cwAPI.capturedPayloads, "counters start over after a flush")
+}
+
+func TestIncr(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	dims := cwatsch.Dimensions(map[string]string{"endpoint": "/users"})
+
+	for i := 0; i < 7; i++ {
+		batch.Incr("myApp", "logins", dims...)
+	}
+
+	batch.Counter("myApp", "logins", dims...).Inc()
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 2, "Incr and Counter keep separate counts")
+
+	var events *cw.MetricDatum
+	for _, d := range data {
+		if d.StatisticValues != nil {
+			events = d
+		}
+	}
+
+	require.NotNil(t, events)
+	assert.Nil(t, events.Value)
+	assert.Equal(t, &cw.StatisticSet{
+		SampleCount: aws.Float64(7),
+		Sum:         aws.Float64(7),
+		Minimum:     aws.Float64(1),
+		Maximum:     aws.Float64(1),
+	}, events.StatisticValues)
+
+	require.NoError(t, batch.Flush())
+	assert.Len(t, cwAPI.capturedPayloads, 1, "the count starts over after a flush")
+}
+
+func BenchmarkCounterShared(b *testing.B) {
+	batch := cwatsch.New(nil)
+	dims := cwatsch.Dimensions(map[string]string{"service": "api"})
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			batch.Counter("myApp", "requests", dims...).Inc()
+		}
+	})
+}
+
+func BenchmarkCounterDistinct(b *testing.B) {
+	batch := cwatsch.New(nil)
+
+	var worker int64
+
+	b.RunParallel(func(pb *testing.PB) {
+		name := fmt.Sprintf("requests%d", atomic.AddInt64(&worker, 1))
+
+		for pb.Next() {
+			batch.Counter("myApp", name).Inc()
+		}
+	})
+}
@@ -0,0 +1,103 @@
+// Package cwatschtest provides CloudWatch clients for testing code that sends
+// metrics with cwatsch.
+package cwatschtest
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// FlakyClient is a CloudWatch client failing PutMetricData requests in a
+// scripted, deterministic way. It makes it possible to exercise the resilience
+// paths (retries, re-queueing, error reporting) of the code under test. The
+// requests that don't fail are recorded and available via Inputs.
+//
+// Only PutMetricData and PutMetricDataWithContext are implemented, calling any
+// other method of the client panics.
+type FlakyClient struct {
+	cloudwatchiface.CloudWatchAPI
+
+	// FailFirst is the number of requests that fail with Err before the client
+	// starts to succeed.
+	FailFirst int
+	// ThrottleEvery makes every ThrottleEvery-th request fail with a throttling
+	// error.
+	ThrottleEvery int
+	// FailNamespace makes all the requests to the namespace fail with Err.
+	FailNamespace string
+	// Err is the error failing requests return. Defaults to an InternalFailure
+	// request failure.
+	Err error
+
+	mu     sync.Mutex
+	calls  int
+	inputs []*cw.PutMetricDataInput
+}
+
+// ThrottlingError is the error returned by throttled requests.
+var ThrottlingError = awserr.NewRequestFailure(
+	awserr.New("Throttling", "Rate exceeded", nil), http.StatusBadRequest, "cwatschtest",
+)
+
+var internalFailure = awserr.NewRequestFailure(
+	awserr.New("InternalFailure", "The request processing has failed", nil), http.StatusInternalServerError, "cwatschtest",
+)
+
+func (c *FlakyClient) PutMetricData(input *cw.PutMetricDataInput) (*cw.PutMetricDataOutput, error) {
+	return c.PutMetricDataWithContext(aws.BackgroundContext(), input)
+}
+
+func (c *FlakyClient) PutMetricDataWithContext(
+	ctx aws.Context, input *cw.PutMetricDataInput, _ ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls++
+
+	if err := ctx.Err(); err != nil {
+		return nil, awserr.New(request.CanceledErrorCode, "request context canceled", err)
+	}
+
+	if c.ThrottleEvery > 0 && c.calls%c.ThrottleEvery == 0 {
+		return nil, ThrottlingError
+	}
+
+	if c.calls <= c.FailFirst || (c.FailNamespace != "" && aws.StringValue(input.Namespace) == c.FailNamespace) {
+		return nil, c.err()
+	}
+
+	c.inputs = append(c.inputs, input)
+
+	return &cw.PutMetricDataOutput{}, nil
+}
+
+func (c *FlakyClient) err() error {
+	if c.Err != nil {
+		return c.Err
+	}
+
+	return internalFailure
+}
+
+// Calls returns the number of requests made, failed ones included.
+func (c *FlakyClient) Calls() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls
+}
+
+// Inputs returns the inputs of the requests that succeeded.
+func (c *FlakyClient) Inputs() []*cw.PutMetricDataInput {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*cw.PutMetricDataInput(nil), c.inputs...)
+}
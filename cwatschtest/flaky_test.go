@@ -0,0 +1,81 @@
+package cwatschtest_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch/cwatschtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func input(ns string) *cw.PutMetricDataInput {
+	return &cw.PutMetricDataInput{
+		Namespace:  aws.String(ns),
+		MetricData: []*cw.MetricDatum{{MetricName: aws.String("metric")}},
+	}
+}
+
+func TestFailFirst(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailFirst: 2}
+
+	_, err := client.PutMetricData(input("ns"))
+	require.Error(t, err)
+	assert.Equal(t, "InternalFailure", err.(awserr.Error).Code())
+
+	_, err = client.PutMetricData(input("ns"))
+	require.Error(t, err)
+
+	_, err = client.PutMetricData(input("ns"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, client.Calls())
+	assert.Len(t, client.Inputs(), 1)
+}
+
+func TestThrottleEvery(t *testing.T) {
+	client := &cwatschtest.FlakyClient{ThrottleEvery: 3}
+
+	var throttled []int
+
+	for i := 1; i <= 7; i++ {
+		if _, err := client.PutMetricData(input("ns")); err != nil {
+			assert.True(t, request.IsErrorThrottle(err))
+			throttled = append(throttled, i)
+		}
+	}
+
+	assert.Equal(t, []int{3, 6}, throttled)
+	assert.Len(t, client.Inputs(), 5)
+}
+
+func TestFailNamespace(t *testing.T) {
+	errBoom := errors.New("boom")
+	client := &cwatschtest.FlakyClient{FailNamespace: "broken", Err: errBoom}
+
+	_, err := client.PutMetricData(input("broken"))
+	assert.Equal(t, errBoom, err)
+
+	_, err = client.PutMetricData(input("fine"))
+	require.NoError(t, err)
+
+	require.Len(t, client.Inputs(), 1)
+	assert.Equal(t, "fine", aws.StringValue(client.Inputs()[0].Namespace))
+}
+
+func TestCancelledContext(t *testing.T) {
+	client := &cwatschtest.FlakyClient{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.PutMetricDataWithContext(ctx, input("ns"))
+	require.Error(t, err)
+	assert.Equal(t, request.CanceledErrorCode, err.(awserr.Error).Code())
+	assert.Empty(t, client.Inputs())
+}
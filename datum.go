@@ -0,0 +1,68 @@
+package cwatsch
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// DatumBuilder builds cloudwatch.MetricDatum without the pointer boilerplate.
+// Create it with Datum.
+type DatumBuilder struct {
+	datum cw.MetricDatum
+}
+
+// Datum starts building a MetricDatum with the given metric name:
+//
+//	batch.Add("myApp", cwatsch.Datum("latency").
+//		Value(12).
+//		Unit(cloudwatch.StandardUnitMilliseconds).
+//		Dim("endpoint", "/users").
+//		Build())
+func Datum(name string) *DatumBuilder {
+	return &DatumBuilder{datum: cw.MetricDatum{MetricName: aws.String(name)}}
+}
+
+// Value sets the value of the datum.
+func (db *DatumBuilder) Value(v float64) *DatumBuilder {
+	db.datum.Value = aws.Float64(v)
+	return db
+}
+
+// Unit sets the unit of the datum. Use one of cloudwatch.StandardUnit*
+// constants.
+func (db *DatumBuilder) Unit(u string) *DatumBuilder {
+	db.datum.Unit = aws.String(u)
+	return db
+}
+
+// Dim appends a dimension to the datum.
+func (db *DatumBuilder) Dim(name, value string) *DatumBuilder {
+	db.datum.Dimensions = append(db.datum.Dimensions, &cw.Dimension{
+		Name:  aws.String(name),
+		Value: aws.String(value),
+	})
+
+	return db
+}
+
+// At sets the timestamp of the datum.
+func (db *DatumBuilder) At(t time.Time) *DatumBuilder {
+	db.datum.Timestamp = aws.Time(t)
+	return db
+}
+
+// Build returns the built datum. If the timestamp wasn't set, the current time
+// is used. Every call returns a new datum, so the builder can be reused as a
+// template.
+func (db *DatumBuilder) Build() *cw.MetricDatum {
+	datum := db.datum
+	datum.Dimensions = append([]*cw.Dimension(nil), db.datum.Dimensions...)
+
+	if datum.Timestamp == nil {
+		datum.Timestamp = aws.Time(time.Now())
+	}
+
+	return &datum
+}
@@ -0,0 +1,55 @@
+package cwatsch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatumBuilder(t *testing.T) {
+	ts := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	datum := cwatsch.Datum("latency").
+		Value(12).
+		Unit(cw.StandardUnitMilliseconds).
+		Dim("endpoint", "/users").
+		Dim("method", "GET").
+		At(ts).
+		Build()
+
+	assert.Equal(t, &cw.MetricDatum{
+		MetricName: aws.String("latency"),
+		Value:      aws.Float64(12),
+		Unit:       aws.String(cw.StandardUnitMilliseconds),
+		Dimensions: []*cw.Dimension{
+			{Name: aws.String("endpoint"), Value: aws.String("/users")},
+			{Name: aws.String("method"), Value: aws.String("GET")},
+		},
+		Timestamp: aws.Time(ts),
+	}, datum)
+}
+
+func TestDatumBuilderDefaultsTimestampToNow(t *testing.T) {
+	before := time.Now()
+	datum := cwatsch.Datum("calls").Value(1).Build()
+
+	require.NotNil(t, datum.Timestamp)
+	assert.False(t, datum.Timestamp.Before(before))
+}
+
+func TestDatumBuilderCanBeReused(t *testing.T) {
+	builder := cwatsch.Datum("calls").Dim("service", "api")
+
+	first := builder.Value(1).Build()
+	second := builder.Dim("region", "eu").Value(2).Build()
+
+	assert.Len(t, first.Dimensions, 1)
+	assert.Equal(t, 1.0, aws.Float64Value(first.Value))
+	assert.Len(t, second.Dimensions, 2)
+	assert.Equal(t, 2.0, aws.Float64Value(second.Value))
+}
@@ -0,0 +1,112 @@
+package cwatsch
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithDeadLetter registers a function receiving the datums that are dropped
+// because the request carrying them failed (and, with WithRequeueOnError,
+// they've run out of attempts), so they can be stored for a later replay
+// instead of being lost. The calls are serialized, the function doesn't need to
+// be safe for concurrent use, but it should return quickly as it holds up the
+// flush. See FileDeadLetter for a simple implementation.
+func WithDeadLetter(fn func(namespace string, data []*cw.MetricDatum)) Option {
+	return func(b *Batch) {
+		b.deadLetter = fn
+	}
+}
+
+func (b *Batch) sendToDeadLetter(ns string, data []*cw.MetricDatum) {
+	if b.deadLetter == nil {
+		return
+	}
+
+	b.deadLetterMu.Lock()
+	defer b.deadLetterMu.Unlock()
+
+	b.deadLetter(ns, data)
+}
+
+// FileDeadLetter appends the dead letters to a file, one JSON encoded
+// PutMetricDataInput per line. ReadDeadLetters reads them back:
+//
+//	dl, err := cwatsch.NewFileDeadLetter("/var/lib/myapp/metrics.dead")
+//	if err != nil {
+//		return err
+//	}
+//	defer dl.Close()
+//
+//	batch := cwatsch.New(cwAPI, cwatsch.WithDeadLetter(dl.Write))
+//
+//	// later, e.g. on the next start
+//	inputs, err := cwatsch.ReadDeadLetters("/var/lib/myapp/metrics.dead")
+//	if err == nil {
+//		batch.AddInputs(inputs...)
+//	}
+type FileDeadLetter struct {
+	// OnError receives the errors of writing to the file. Optional.
+	OnError func(error)
+
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// NewFileDeadLetter opens the file for appending, creating it if needed.
+func NewFileDeadLetter(path string) (*FileDeadLetter, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileDeadLetter{file: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Write appends the datums to the file. Its signature matches WithDeadLetter.
+func (d *FileDeadLetter) Write(namespace string, data []*cw.MetricDatum) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := d.enc.Encode(&cw.PutMetricDataInput{Namespace: aws.String(namespace), MetricData: data})
+	if err != nil && d.OnError != nil {
+		d.OnError(err)
+	}
+}
+
+// Close closes the file.
+func (d *FileDeadLetter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.file.Close()
+}
+
+// ReadDeadLetters reads the inputs written by FileDeadLetter.
+func ReadDeadLetters(path string) ([]*cw.PutMetricDataInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var inputs []*cw.PutMetricDataInput
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		input := &cw.PutMetricDataInput{}
+		if err := dec.Decode(input); err != nil {
+			return inputs, err
+		}
+
+		inputs = append(inputs, input)
+	}
+
+	return inputs, nil
+}
@@ -0,0 +1,77 @@
+package cwatsch_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeadLetter(t *testing.T) {
+	cwAPI := cwMock{err: errors.New("boom")}
+
+	var dead []*cw.MetricDatum
+
+	batch := cwatsch.New(&cwAPI,
+		cwatsch.WithRequeueOnError(2),
+		cwatsch.WithDeadLetter(func(ns string, data []*cw.MetricDatum) {
+			assert.Equal(t, "myApp", ns)
+			dead = append(dead, data...)
+		}),
+	)
+
+	batch.Add("myApp", metricData("metric", 3)...)
+
+	require.Error(t, batch.Flush())
+	assert.Empty(t, dead, "the datums are requeued after the first failure")
+
+	require.Error(t, batch.Flush())
+	assert.Equal(t, metricData("metric", 3), dead)
+	assert.Equal(t, int64(3), batch.Stats().Dropped)
+}
+
+func TestFileDeadLetter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.dead")
+
+	dl, err := cwatsch.NewFileDeadLetter(path)
+	require.NoError(t, err)
+
+	batch := cwatsch.New(&cwMock{err: errors.New("boom")}, cwatsch.WithDeadLetter(dl.Write))
+
+	ts := time.Now()
+	batch.Add("myApp", cwatsch.Datum("latency").Value(5).Dim("Endpoint", "/users").At(ts).Build())
+	batch.Add("other", metricData("metric", 2)...)
+
+	// a failed request cancels the rest of the flush, the cancelled batches
+	// stay buffered
+	for batch.Len() > 0 {
+		require.Error(t, batch.Flush())
+	}
+
+	require.NoError(t, dl.Close())
+
+	inputs, err := cwatsch.ReadDeadLetters(path)
+	require.NoError(t, err)
+	require.Len(t, inputs, 2)
+
+	sortByNS(inputs)
+	assert.Equal(t, "myApp", aws.StringValue(inputs[0].Namespace))
+	require.Len(t, inputs[0].MetricData, 1)
+
+	d := inputs[0].MetricData[0]
+	assert.Equal(t, "latency", aws.StringValue(d.MetricName))
+	assert.Equal(t, 5.0, aws.Float64Value(d.Value))
+	assert.Equal(t, map[string]string{"Endpoint": "/users"}, cwatsch.DimensionsMap(d.Dimensions))
+	assert.True(t, ts.Equal(aws.TimeValue(d.Timestamp)))
+	assert.Equal(t, metricData("metric", 2), inputs[1].MetricData)
+
+	replayed := cwMock{}
+	require.NoError(t, cwatsch.New(&replayed).AddInputs(inputs...).Flush())
+	assert.Len(t, replayed.capturedPayloads, 2)
+}
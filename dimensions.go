@@ -0,0 +1,179 @@
+package cwatsch
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Dimensions converts the map into dimensions sorted by name. The order is
+// deterministic, so equal maps always produce equal dimensions.
+func Dimensions(m map[string]string) []*cw.Dimension {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	dims := make([]*cw.Dimension, len(names))
+	for i, name := range names {
+		dims[i] = &cw.Dimension{Name: aws.String(name), Value: aws.String(m[name])}
+	}
+
+	return dims
+}
+
+// DimensionsMap converts the dimensions into a map from dimension name to
+// value. It's the inverse of Dimensions.
+func DimensionsMap(dims []*cw.Dimension) map[string]string {
+	m := make(map[string]string, len(dims))
+	for _, d := range dims {
+		if d != nil {
+			m[aws.StringValue(d.Name)] = aws.StringValue(d.Value)
+		}
+	}
+
+	return m
+}
+
+// WithCohort attaches a constant cohort dimension (e.g. "canary" or "stable")
+// to all the metrics of the batch, which makes it easy to compare the cohorts
+// of a deployment. The value is usually taken from the environment:
+//
+//	cwatsch.New(cwAPI, cwatsch.WithCohort("Cohort", os.Getenv("DEPLOY_COHORT")))
+//
+// An empty value is ignored, since CloudWatch rejects empty dimension values.
+// A dimension with the same name set on a datum takes precedence.
+func WithCohort(dimName, value string) Option {
+	return func(b *Batch) {
+		if value == "" {
+			return
+		}
+
+		b.defaultDims = mergeDimensions(b.defaultDims, []*cw.Dimension{{
+			Name:  aws.String(dimName),
+			Value: aws.String(value),
+		}})
+	}
+}
+
+// WithDefaultDimensions attaches the dimensions to all the metrics of the batch,
+// e.g. the service, environment and version. A dimension with the same name set
+// on a datum takes precedence.
+func WithDefaultDimensions(dims ...*cw.Dimension) Option {
+	return func(b *Batch) {
+		b.defaultDims = mergeDimensions(b.defaultDims, dims)
+	}
+}
+
+// SetDefaultDimensions replaces the dimensions attached to all the metrics of
+// the batch (see WithDefaultDimensions and WithCohort). It applies to the
+// metrics added afterwards, the ones already collected keep their dimensions.
+func (b *Batch) SetDefaultDimensions(dims ...*cw.Dimension) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.defaultDims = mergeDimensions(nil, dims)
+}
+
+// WithNamePrefix prefixes the names of all the metrics of the namespace, e.g.
+// all the metrics in the "db" namespace get the "db_" prefix. This helps to
+// enforce naming conventions when several sources share a namespace. Names that
+// already start with the prefix are left as they are.
+func WithNamePrefix(namespace, prefix string) Option {
+	return func(b *Batch) {
+		if b.namePrefixes == nil {
+			b.namePrefixes = map[string]string{}
+		}
+
+		b.namePrefixes[namespace] = prefix
+	}
+}
+
+// mergeDimensions returns dims extended by those of defaults whose names aren't
+// present in dims.
+func mergeDimensions(dims, defaults []*cw.Dimension) []*cw.Dimension {
+	merged := make([]*cw.Dimension, len(dims), len(dims)+len(defaults))
+	copy(merged, dims)
+
+	for _, dflt := range defaults {
+		if dflt != nil && !hasDimension(merged, aws.StringValue(dflt.Name)) {
+			merged = append(merged, dflt)
+		}
+	}
+
+	return merged
+}
+
+func hasDimension(dims []*cw.Dimension, name string) bool {
+	for _, d := range dims {
+		if d != nil && aws.StringValue(d.Name) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shareDimensions returns the datums of one request, those having the same
+// dimensions as an earlier datum of the request referencing the earlier
+// datum's slice. The datums themselves are left untouched, since they may be
+// the caller's or be put back into the queue: shallow copies are made instead.
+// The interning doesn't outlive the request.
+func shareDimensions(batch []*cw.MetricDatum) []*cw.MetricDatum {
+	if len(batch) < 2 {
+		return batch
+	}
+
+	data := batch
+	copied := false
+	dimSets := make(map[string][]*cw.Dimension, len(batch))
+
+	var key []byte
+
+	for i, d := range batch {
+		if d == nil || len(d.Dimensions) == 0 {
+			continue
+		}
+
+		key = dimensionsKey(key[:0], d.Dimensions)
+
+		shared, ok := dimSets[string(key)]
+		if !ok {
+			dimSets[string(key)] = d.Dimensions
+			continue
+		}
+
+		if &shared[0] == &d.Dimensions[0] {
+			continue
+		}
+
+		if !copied {
+			data = make([]*cw.MetricDatum, len(batch))
+			copy(data, batch)
+			copied = true
+		}
+
+		c := *d
+		c.Dimensions = shared
+		data[i] = &c
+	}
+
+	return data
+}
+
+// dimensionsKey appends the names and values of the dimensions to key.
+func dimensionsKey(key []byte, dims []*cw.Dimension) []byte {
+	for _, d := range dims {
+		if d != nil {
+			key = append(key, aws.StringValue(d.Name)...)
+			key = append(key, 0)
+			key = append(key, aws.StringValue(d.Value)...)
+			key = append(key, 1)
+		}
+	}
+
+	return key
+}
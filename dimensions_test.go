@@ -0,0 +1,157 @@
+package cwatsch_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCohort(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithCohort("Cohort", "canary"))
+
+	datum := &cw.MetricDatum{
+		MetricName: aws.String("calls"),
+		Dimensions: []*cw.Dimension{{Name: aws.String("Endpoint"), Value: aws.String("/users")}},
+	}
+	batch.Add("myApp", datum, &cw.MetricDatum{
+		MetricName: aws.String("errors"),
+		Dimensions: []*cw.Dimension{{Name: aws.String("Cohort"), Value: aws.String("stable")}},
+	})
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	assert.Equal(t, []*cw.MetricDatum{{
+		MetricName: aws.String("calls"),
+		Dimensions: []*cw.Dimension{
+			{Name: aws.String("Endpoint"), Value: aws.String("/users")},
+			{Name: aws.String("Cohort"), Value: aws.String("canary")},
+		},
+	}, {
+		MetricName: aws.String("errors"),
+		Dimensions: []*cw.Dimension{{Name: aws.String("Cohort"), Value: aws.String("stable")}},
+	}}, cwAPI.capturedPayloads[0].MetricData)
+
+	assert.Len(t, datum.Dimensions, 1, "caller's datum must not be modified")
+}
+
+func TestWithCohortIgnoresEmptyValue(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithCohort("Cohort", ""))
+
+	batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("calls")})
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Empty(t, cwAPI.capturedPayloads[0].MetricData[0].Dimensions)
+}
+
+func TestWithDefaultDimensions(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithDefaultDimensions(cwatsch.Dimensions(map[string]string{
+		"Service":     "users",
+		"Environment": "prod",
+	})...))
+
+	batch.Add("myApp",
+		cwatsch.Datum("calls").Build(),
+		cwatsch.Datum("errors").Dim("Environment", "staging").Build(),
+	)
+
+	batch.SetDefaultDimensions(&cw.Dimension{Name: aws.String("Version"), Value: aws.String("1.2.3")})
+	batch.Add("myApp", cwatsch.Datum("calls").Build())
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 3)
+	assert.Equal(t, map[string]string{"Service": "users", "Environment": "prod"}, cwatsch.DimensionsMap(data[0].Dimensions))
+	assert.Equal(t, map[string]string{"Service": "users", "Environment": "staging"}, cwatsch.DimensionsMap(data[1].Dimensions),
+		"the datum's dimensions take precedence over the defaults")
+	assert.Equal(t, map[string]string{"Version": "1.2.3"}, cwatsch.DimensionsMap(data[2].Dimensions))
+}
+
+func TestWithNamePrefix(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithNamePrefix("db", "db_"), cwatsch.WithNamePrefix("api", ""))
+
+	datum := &cw.MetricDatum{MetricName: aws.String("queries")}
+	batch.Add("db", datum, &cw.MetricDatum{MetricName: aws.String("db_connections")})
+	batch.Add("api", &cw.MetricDatum{MetricName: aws.String("calls")})
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 2)
+
+	names := map[string][]string{}
+	for _, p := range cwAPI.capturedPayloads {
+		for _, d := range p.MetricData {
+			names[aws.StringValue(p.Namespace)] = append(names[aws.StringValue(p.Namespace)], aws.StringValue(d.MetricName))
+		}
+	}
+
+	assert.Equal(t, map[string][]string{
+		"db":  {"db_queries", "db_connections"},
+		"api": {"calls"},
+	}, names)
+	assert.Equal(t, "queries", aws.StringValue(datum.MetricName), "caller's datum must not be modified")
+}
+
+func TestDimensions(t *testing.T) {
+	dims := cwatsch.Dimensions(map[string]string{"Service": "api", "Env": "prod", "AZ": "eu-west-1a"})
+
+	assert.Equal(t, []*cw.Dimension{
+		{Name: aws.String("AZ"), Value: aws.String("eu-west-1a")},
+		{Name: aws.String("Env"), Value: aws.String("prod")},
+		{Name: aws.String("Service"), Value: aws.String("api")},
+	}, dims)
+
+	assert.Equal(t, map[string]string{"Service": "api", "Env": "prod", "AZ": "eu-west-1a"}, cwatsch.DimensionsMap(dims))
+	assert.Empty(t, cwatsch.Dimensions(nil))
+}
+
+func TestDefaultDimensionsAreShared(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithCohort("cohort", "canary"))
+
+	dims := cwatsch.Dimensions(map[string]string{"service": "api"})
+	calls := &cw.MetricDatum{MetricName: aws.String("calls"), Dimensions: cwatsch.Dimensions(map[string]string{"service": "api"})}
+	batch.Add("myApp",
+		&cw.MetricDatum{MetricName: aws.String("latency"), Dimensions: dims},
+		calls,
+		&cw.MetricDatum{MetricName: aws.String("errors"), Dimensions: cwatsch.Dimensions(map[string]string{"service": "web"})},
+	)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+
+	assert.Same(t, &data[0].Dimensions[0], &data[1].Dimensions[0], "identical dimension sets share a slice within a request")
+	assert.NotSame(t, &data[0].Dimensions[0], &data[2].Dimensions[0])
+	assert.Equal(t, "web", aws.StringValue(data[2].Dimensions[0].Value))
+	assert.Len(t, data[0].Dimensions, 2)
+	assert.Len(t, dims, 1, "caller's dimensions are not modified")
+	assert.Len(t, calls.Dimensions, 1, "caller's datum is not modified")
+}
+
+func BenchmarkAddWithDefaultDimensions(b *testing.B) {
+	batch := cwatsch.New(nil, cwatsch.WithCohort("cohort", "canary"))
+	dims := cwatsch.Dimensions(map[string]string{"service": "api", "endpoint": "/users"})
+	discard := func(context.Context, *cw.PutMetricDataInput) error { return nil }
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("latency"), Dimensions: dims, Value: aws.Float64(1)})
+
+		if i%1000 == 999 {
+			_ = batch.FlushTo(context.Background(), discard)
+		}
+	}
+}
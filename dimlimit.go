@@ -0,0 +1,73 @@
+package cwatsch
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// DimensionLimitPolicy defines what happens to a datum having more than 30
+// dimensions once the default dimensions are merged in. CloudWatch rejects
+// such datums, and with them the whole request they are sent in.
+type DimensionLimitPolicy int
+
+const (
+	// RejectExcessDimensions drops the datum and reports a *DatumError via
+	// WithOnError. It's the default policy.
+	RejectExcessDimensions DimensionLimitPolicy = iota
+	// TruncateDimensions keeps the first 30 dimensions. The datum's own
+	// dimensions come before the default ones, so the defaults are cut off
+	// first.
+	TruncateDimensions
+)
+
+// WithDimensionLimitPolicy sets how the datums having more than 30 dimensions
+// are handled. The truncated datums are counted as modifications (see
+// WithInternalMetrics).
+func WithDimensionLimitPolicy(policy DimensionLimitPolicy) Option {
+	return func(b *Batch) {
+		b.dimensionLimitPolicy = policy
+	}
+}
+
+// limitDimensions returns the datum with the dimensions of the same name
+// deduplicated (the first one wins) and at most 30 dimensions. edit must be
+// used to get a datum that can be altered.
+func (b *Batch) limitDimensions(
+	ns string, datum *cw.MetricDatum, edit func() *cw.MetricDatum,
+) (*cw.MetricDatum, error) {
+	dims := datum.Dimensions
+
+	if hasDuplicateDimensions(dims) {
+		dims = mergeDimensions(nil, dims)
+		datum = edit()
+		datum.Dimensions = dims[:len(dims):len(dims)]
+
+		b.modified("DimensionsDeduplicated")
+	}
+
+	if len(dims) <= maxDimensions {
+		return datum, nil
+	}
+
+	if b.dimensionLimitPolicy != TruncateDimensions {
+		return nil, &DatumError{Namespace: ns, Datum: datum, Reason: "datum has more than 30 dimensions"}
+	}
+
+	// capped, so that appending to the truncated slice never writes into the
+	// dimensions cut off
+	edit().Dimensions = dims[:maxDimensions:maxDimensions]
+
+	b.modified("DimensionsTruncated")
+
+	return edit(), nil
+}
+
+func hasDuplicateDimensions(dims []*cw.Dimension) bool {
+	for i, d := range dims {
+		if d != nil && hasDimension(dims[:i], aws.StringValue(d.Name)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,91 @@
+package cwatsch_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedDimensions(prefix string, n int) []*cw.Dimension {
+	dims := make([]*cw.Dimension, n)
+	for i := range dims {
+		dims[i] = &cw.Dimension{Name: aws.String(fmt.Sprintf("%s%02d", prefix, i)), Value: aws.String("v")}
+	}
+
+	return dims
+}
+
+func TestExcessDimensionsAreRejected(t *testing.T) {
+	cwAPI := cwMock{}
+
+	var errs []error
+
+	batch := cwatsch.New(&cwAPI,
+		cwatsch.WithDefaultDimensions(namedDimensions("default", 10)...),
+		cwatsch.WithOnError(func(err error) { errs = append(errs, err) }),
+	)
+
+	batch.Add("myApp",
+		&cw.MetricDatum{MetricName: aws.String("wide"), Dimensions: namedDimensions("own", 25)},
+		&cw.MetricDatum{MetricName: aws.String("narrow"), Dimensions: namedDimensions("own", 20)},
+	)
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	require.Len(t, cwAPI.capturedPayloads[0].MetricData, 1)
+	assert.Equal(t, "narrow", aws.StringValue(cwAPI.capturedPayloads[0].MetricData[0].MetricName))
+
+	require.Len(t, errs, 1)
+
+	var datumErr *cwatsch.DatumError
+	require.True(t, errors.As(errs[0], &datumErr))
+	assert.Equal(t, "wide", aws.StringValue(datumErr.Datum.MetricName))
+	assert.Equal(t, int64(1), batch.Stats().Dropped)
+}
+
+func TestTruncateDimensions(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI,
+		cwatsch.WithDefaultDimensions(namedDimensions("default", 10)...),
+		cwatsch.WithDimensionLimitPolicy(cwatsch.TruncateDimensions),
+	)
+
+	batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("wide"), Dimensions: namedDimensions("own", 25)})
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	dims := cwAPI.capturedPayloads[0].MetricData[0].Dimensions
+	assert.Equal(t, append(namedDimensions("own", 25), namedDimensions("default", 5)...), dims)
+	assert.Equal(t, int64(1), batch.Stats().Modified)
+}
+
+func TestDuplicateDimensionsAreRemoved(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	datum := &cw.MetricDatum{
+		MetricName: aws.String("calls"),
+		Dimensions: []*cw.Dimension{
+			{Name: aws.String("Endpoint"), Value: aws.String("/users")},
+			{Name: aws.String("Method"), Value: aws.String("GET")},
+			{Name: aws.String("Endpoint"), Value: aws.String("/orders")},
+		},
+	}
+	batch.Add("myApp", datum)
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	assert.Equal(t, []*cw.Dimension{
+		{Name: aws.String("Endpoint"), Value: aws.String("/users")},
+		{Name: aws.String("Method"), Value: aws.String("GET")},
+	}, cwAPI.capturedPayloads[0].MetricData[0].Dimensions)
+	assert.Len(t, datum.Dimensions, 3, "caller's datum must not be modified")
+}
@@ -0,0 +1,184 @@
+package cwatsch
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// diskBufferFile is the name of the file WithDiskBuffer keeps the collected
+// metrics in.
+const diskBufferFile = "cwatsch-buffer.jsonl"
+
+// WithDiskBuffer makes the batch keep a copy of the collected metrics in a file
+// in dir, so they survive a crash of the process. The metrics found in the file
+// are added to the batch by New, e.g. when a job is restarted after it crashed
+// before flushing.
+//
+// Every add appends the accepted datums to the file as one JSON encoded
+// PutMetricDataInput per line. After every flush the file is rewritten to hold
+// just the metrics still buffered, so it doesn't grow beyond the buffer. On
+// replay, lines that can't be decoded (e.g. the last one being cut short by the
+// crash) are skipped, the rest is replayed.
+//
+// The file isn't synced to the disk after every write, so the metrics survive
+// a crash of the process, but not necessarily a crash of the machine. The
+// metrics being sent at the moment of the crash may be lost or sent twice. The
+// errors of reading and writing the file are reported via WithOnError.
+func WithDiskBuffer(dir string) Option {
+	return func(b *Batch) {
+		b.diskBufferDir = dir
+	}
+}
+
+type diskBuffer struct {
+	path string
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openDiskBuffer replays the metrics found in the disk buffer and starts
+// recording the added ones.
+func (b *Batch) openDiskBuffer() {
+	path := filepath.Join(b.diskBufferDir, diskBufferFile)
+
+	inputs, err := readDiskBuffer(path)
+	if err != nil && !os.IsNotExist(err) {
+		b.reportError(err)
+	}
+
+	b.Lock()
+
+	var errs []error
+	for _, input := range inputs {
+		errs = append(errs, b.addLocked(input)...)
+	}
+
+	b.disk = &diskBuffer{path: path}
+	err = b.rewriteDiskBuffer()
+
+	b.Unlock()
+
+	for _, err := range errs {
+		b.reportError(err)
+	}
+
+	b.reportError(err)
+}
+
+// readDiskBuffer reads the inputs from the file, skipping the lines that can't
+// be decoded.
+func readDiskBuffer(path string) ([]*cw.PutMetricDataInput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var inputs []*cw.PutMetricDataInput
+
+	r := bufio.NewReader(f)
+
+	for {
+		line, err := r.ReadBytes('\n')
+
+		input := &cw.PutMetricDataInput{}
+		if len(line) > 0 && json.Unmarshal(line, input) == nil {
+			inputs = append(inputs, input)
+		}
+
+		if err == io.EOF {
+			return inputs, nil
+		}
+
+		if err != nil {
+			return inputs, err
+		}
+	}
+}
+
+// appendToDiskBuffer records the datums added to the namespace. Must be called
+// with the lock held.
+func (b *Batch) appendToDiskBuffer(ns string, data []*cw.MetricDatum) error {
+	if b.disk == nil || b.disk.enc == nil || len(data) == 0 {
+		return nil
+	}
+
+	return b.disk.enc.Encode(&cw.PutMetricDataInput{Namespace: aws.String(ns), MetricData: data})
+}
+
+// rewriteDiskBuffer replaces the content of the disk buffer with the metrics
+// currently buffered. Must be called with the lock held.
+func (b *Batch) rewriteDiskBuffer() error {
+	if b.disk == nil {
+		return nil
+	}
+
+	if b.disk.file != nil {
+		b.disk.file.Close()
+		b.disk.file, b.disk.enc = nil, nil
+	}
+
+	tmp := b.disk.path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+
+	for ns, q := range b.metricQs {
+		data := make([]*cw.MetricDatum, 0, q.count)
+		q.each(func(d *cw.MetricDatum) {
+			if d != nil {
+				data = append(data, d)
+			}
+		})
+
+		if len(data) == 0 {
+			continue
+		}
+
+		if err := enc.Encode(&cw.PutMetricDataInput{Namespace: aws.String(ns), MetricData: data}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, b.disk.path); err != nil {
+		return err
+	}
+
+	f, err = os.OpenFile(b.disk.path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	b.disk.file, b.disk.enc = f, json.NewEncoder(f)
+
+	return nil
+}
+
+// syncDiskBuffer rewrites the disk buffer after a flush.
+func (b *Batch) syncDiskBuffer() {
+	if b.disk == nil {
+		return
+	}
+
+	b.Lock()
+	err := b.rewriteDiskBuffer()
+	b.Unlock()
+
+	b.reportError(err)
+}
@@ -0,0 +1,65 @@
+package cwatsch_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskBufferSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	crashed := cwatsch.New(&cwMock{}, cwatsch.WithDiskBuffer(dir))
+	crashed.Add("myApp", metricData("metric", 3)...)
+	crashed.Add("other", metricData("metric", 1)...)
+
+	cwAPI := cwMock{}
+	restarted := cwatsch.New(&cwAPI, cwatsch.WithDiskBuffer(dir))
+	assert.Equal(t, 4, restarted.Len())
+
+	require.NoError(t, restarted.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 2)
+
+	sortByNS(cwAPI.capturedPayloads)
+	assert.Equal(t, metricData("metric", 3), cwAPI.capturedPayloads[0].MetricData)
+	assert.Equal(t, metricData("metric", 1), cwAPI.capturedPayloads[1].MetricData)
+
+	assert.Equal(t, 0, cwatsch.New(&cwMock{}, cwatsch.WithDiskBuffer(dir)).Len(), "flushed metrics are removed")
+}
+
+func TestDiskBufferKeepsUnflushedMetrics(t *testing.T) {
+	dir := t.TempDir()
+
+	batch := cwatsch.New(&cwMock{}, cwatsch.WithDiskBuffer(dir))
+	batch.Add("myApp", metricData("metric", 25)...)
+	require.NoError(t, batch.FlushCompleteBatches())
+
+	restarted := cwatsch.New(&cwMock{}, cwatsch.WithDiskBuffer(dir))
+	assert.Equal(t, 5, restarted.Len())
+
+	restarted.Reset()
+	assert.Equal(t, 0, cwatsch.New(&cwMock{}, cwatsch.WithDiskBuffer(dir)).Len())
+}
+
+func TestDiskBufferSkipsCorruptedLines(t *testing.T) {
+	dir := t.TempDir()
+
+	content := `{"Namespace":"myApp","MetricData":[{"MetricName":"metric0"}]}
+garbage
+{"Namespace":"myApp","MetricData":[{"MetricName":"metric1"}]}
+{"Namespace":"myApp","MetricData":[{"MetricNa`
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cwatsch-buffer.jsonl"), []byte(content), 0o644))
+
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithDiskBuffer(dir))
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Equal(t, "myApp", aws.StringValue(cwAPI.capturedPayloads[0].Namespace))
+	assert.Equal(t, metricData("metric", 2), cwAPI.capturedPayloads[0].MetricData)
+}
@@ -0,0 +1,49 @@
+package cwatsch
+
+import (
+	"context"
+	"time"
+
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// LaunchDrain creates a background job that periodically drains all the
+// collected metrics into fn, the same way FlushTo does. Together with
+// LaunchAutoFlush this forms a two-stage pipeline where each stage runs on its
+// own cadence: a fast local drain (e.g. into a spill file or a forwarder)
+// feeding a slower remote sender. For example:
+//
+//	remote := cwatsch.New(cwAPI)
+//	remote.LaunchAutoFlush(ctx, time.Minute, nil)
+//
+//	local := cwatsch.New(nil)
+//	local.LaunchDrain(ctx, time.Second, remote.Receive, nil)
+//
+// onError is an optional parameter (nil can be provided). When ctx is
+// cancelled, the job drains the remaining metrics one last time (giving up after
+// 5 seconds) before it stops.
+func (b *Batch) LaunchDrain(ctx context.Context, interval time.Duration, fn SendFunc, onError func(error)) {
+	go func() {
+		NewTicker(ctx, interval, func() {
+			err := b.FlushTo(ctx, fn)
+			if onError != nil {
+				onError(err)
+			}
+		})
+
+		// the metrics added since the last tick would be lost otherwise
+		drainCtx, cancel := context.WithTimeout(context.Background(), finalFlushTimeout)
+		defer cancel()
+
+		if err := b.FlushTo(drainCtx, fn); err != nil && onError != nil {
+			onError(err)
+		}
+	}()
+}
+
+// Receive adds the input to the batch. It's a SendFunc, so that the batch can
+// be used as the target of another batch's FlushTo or LaunchDrain.
+func (b *Batch) Receive(_ context.Context, input *cw.PutMetricDataInput) error {
+	b.AddInputs(input)
+	return nil
+}
@@ -0,0 +1,57 @@
+package cwatsch_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLaunchDrain(t *testing.T) {
+	cwAPI := cwMock{}
+	remote := cwatsch.New(&cwAPI)
+	local := cwatsch.New(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local.LaunchDrain(ctx, time.Millisecond, remote.Receive, nil)
+
+	local.Add("myApp", &cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)})
+
+	assert.Eventually(t, func() bool {
+		return remote.Stats().Pending == 1
+	}, time.Second, time.Millisecond)
+
+	assert.Empty(t, cwAPI.payloads(), "draining doesn't send to CloudWatch")
+	assert.Equal(t, int64(0), local.Stats().Pending)
+
+	require.NoError(t, remote.Flush())
+
+	payloads := cwAPI.payloads()
+	require.Len(t, payloads, 1)
+	assert.Equal(t, "myApp", aws.StringValue(payloads[0].Namespace))
+	assert.Equal(t, "calls", aws.StringValue(payloads[0].MetricData[0].MetricName))
+}
+
+func TestLaunchDrainDrainsOnCancel(t *testing.T) {
+	remote := cwatsch.New(nil)
+	local := cwatsch.New(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	local.LaunchDrain(ctx, time.Hour, remote.Receive, nil)
+
+	local.Add("myApp", &cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)})
+	cancel()
+
+	assert.Eventually(t, func() bool {
+		return remote.Stats().Pending == 1
+	}, time.Second, time.Millisecond, "the remaining metrics are drained when ctx is cancelled")
+	assert.Equal(t, int64(0), local.Stats().Pending)
+}
@@ -0,0 +1,48 @@
+package cwatsch
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// DatumError reports a datum the batch refused to queue. Such errors are passed
+// to the function registered with WithOnError. Datum is nil if a nil datum has
+// been added.
+type DatumError struct {
+	Namespace string
+	Datum     *cw.MetricDatum
+	Reason    string
+}
+
+func (e *DatumError) Error() string {
+	return fmt.Sprintf("cwatsch: datum %q in namespace %q rejected: %s",
+		e.metricName(), e.Namespace, e.Reason)
+}
+
+func (e *DatumError) metricName() string {
+	if e.Datum == nil {
+		return ""
+	}
+
+	return aws.StringValue(e.Datum.MetricName)
+}
+
+// FlushError reports a request that failed during a flush along with the
+// namespace of the metrics it carried. If several requests of a flush fail,
+// the flush returns their FlushErrors joined with errors.Join, so that all the
+// failing namespaces are listed. A flush failing a single request returns its
+// error as it is.
+type FlushError struct {
+	Namespace string
+	Err       error
+}
+
+func (e *FlushError) Error() string {
+	return fmt.Sprintf("cwatsch: flushing namespace %q: %v", e.Namespace, e.Err)
+}
+
+func (e *FlushError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,52 @@
+package cwatsch
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Event emits a single datum with value 1 (unit Count) and sends it right away,
+// bypassing the buffer, so that it shows up on the dashboards immediately. It's
+// meant for annotations like deployments or incidents:
+//
+//	batch.Event("myApp", "Deployment", &cloudwatch.Dimension{
+//		Name:  aws.String("Version"),
+//		Value: aws.String(version),
+//	})
+func (b *Batch) Event(namespace, name string, dims ...*cw.Dimension) error {
+	return b.EventCtx(context.Background(), namespace, name, dims...)
+}
+
+// EventCtx works the same way Event does, with ctx limiting the request. The
+// event is never buffered, if ctx is done before it's sent, it's discarded and
+// the context's error is returned.
+func (b *Batch) EventCtx(ctx context.Context, namespace, name string, dims ...*cw.Dimension) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	b.Lock()
+	datum, err := b.prepare(namespace, &cw.MetricDatum{
+		MetricName: aws.String(name),
+		Dimensions: dims,
+		Value:      aws.Float64(1),
+		Unit:       aws.String(cw.StandardUnitCount),
+		Timestamp:  aws.Time(time.Now()),
+	})
+	b.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// the event bypasses the buffer, it isn't put there if the request is
+	// cancelled or fails
+	flush, ctx := b.newFlush(ctx, b.send)
+	flush.requeue, flush.failed = nil, nil
+	flush.do(ctx, namespace, []*cw.MetricDatum{datum})
+
+	return flush.wait()
+}
@@ -0,0 +1,52 @@
+package cwatsch_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventIsSentImmediately(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithCohort("Cohort", "canary"))
+
+	batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("calls")})
+
+	err := batch.Event("myApp", "Deployment", &cw.Dimension{Name: aws.String("Version"), Value: aws.String("1.2.3")})
+	require.NoError(t, err)
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Equal(t, "myApp", aws.StringValue(cwAPI.capturedPayloads[0].Namespace))
+	require.Len(t, cwAPI.capturedPayloads[0].MetricData, 1)
+
+	datum := cwAPI.capturedPayloads[0].MetricData[0]
+	assert.Equal(t, "Deployment", aws.StringValue(datum.MetricName))
+	assert.Equal(t, 1.0, aws.Float64Value(datum.Value))
+	assert.Equal(t, cw.StandardUnitCount, aws.StringValue(datum.Unit))
+	assert.NotNil(t, datum.Timestamp)
+	assert.Equal(t, []*cw.Dimension{
+		{Name: aws.String("Version"), Value: aws.String("1.2.3")},
+		{Name: aws.String("Cohort"), Value: aws.String("canary")},
+	}, datum.Dimensions)
+
+	require.NoError(t, batch.Flush())
+	assert.Len(t, cwAPI.capturedPayloads, 2, "buffered metrics are sent with the next flush")
+}
+
+func TestEventWithCancelledContext(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, batch.EventCtx(ctx, "myApp", "Deployment"))
+	assert.Empty(t, cwAPI.payloads())
+	assert.Equal(t, 0, batch.Len(), "the event isn't buffered")
+	assert.Equal(t, int64(0), batch.Stats().Pending)
+}
@@ -0,0 +1,68 @@
+package cwatsch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+type gauge struct {
+	namespace string
+	datum     *cw.MetricDatum
+}
+
+// Gauge sets the current value of the metric, e.g. the depth of a queue. Only
+// the latest value set before a flush of all the collected metrics is sent,
+// as one datum, no matter how many times the gauge has been set. Nothing is
+// sent for a gauge that hasn't been set since the last flush. The order of the
+// dimensions doesn't matter.
+func (b *Batch) Gauge(namespace, name string, value float64, dims ...*cw.Dimension) {
+	datum := &cw.MetricDatum{
+		MetricName: aws.String(name),
+		Dimensions: append([]*cw.Dimension(nil), dims...),
+		Value:      aws.Float64(value),
+		Timestamp:  aws.Time(time.Now()),
+	}
+
+	key := namespace + "\x00" + seriesKey(datum)
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.gauges == nil {
+		b.gauges = map[string]gauge{}
+	}
+
+	b.gauges[key] = gauge{namespace: namespace, datum: datum}
+}
+
+// collectGauges adds the latest values of the gauges to the buffer and returns
+// the errors of the rejected datums. Must be called with the lock held.
+func (b *Batch) collectGauges() []error {
+	if len(b.gauges) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(b.gauges))
+	for key := range b.gauges {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var errs []error
+
+	for _, key := range keys {
+		g := b.gauges[key]
+		errs = append(errs, b.addLocked(&cw.PutMetricDataInput{
+			Namespace:  aws.String(g.namespace),
+			MetricData: []*cw.MetricDatum{g.datum},
+		})...)
+	}
+
+	b.gauges = nil
+
+	return errs
+}
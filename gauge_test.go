@@ -0,0 +1,46 @@
+package cwatsch_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGauge(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	dims := cwatsch.Dimensions(map[string]string{"queue": "emails", "region": "eu"})
+
+	for i := 0; i < 10000; i++ {
+		batch.Gauge("myApp", "depth", float64(i), dims...)
+	}
+
+	batch.Gauge("myApp", "depth", 42, dims[1], dims[0])
+	batch.Gauge("myApp", "workers", 3)
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 2)
+
+	values := map[string]float64{}
+	for _, d := range data {
+		values[aws.StringValue(d.MetricName)] = aws.Float64Value(d.Value)
+	}
+
+	assert.Equal(t, map[string]float64{"depth": 42, "workers": 3}, values)
+
+	require.NoError(t, batch.Flush())
+	assert.Len(t, cwAPI.capturedPayloads, 1, "gauges that weren't set since the last flush aren't sent")
+
+	batch.Gauge("myApp", "workers", 4)
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 2)
+	require.Len(t, cwAPI.capturedPayloads[1].MetricData, 1)
+	assert.Equal(t, 4.0, aws.Float64Value(cwAPI.capturedPayloads[1].MetricData[0].Value))
+}
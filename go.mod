@@ -1,9 +1,16 @@
 module github.com/molecule-man/cwatsch
 
-go 1.14
+go 1.20
 
 require (
 	github.com/aws/aws-sdk-go v1.31.8
 	github.com/stretchr/testify v1.6.0
 	golang.org/x/sync v0.0.0-20200625203802-6e8e738ad208
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/jmespath/go-jmespath v0.3.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
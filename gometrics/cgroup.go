@@ -0,0 +1,52 @@
+package gometrics
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cpuQuota returns the number of CPUs the cgroup of the process may use, read
+// from cpu.max (cgroup v2) or cpu.cfs_quota_us and cpu.cfs_period_us (cgroup
+// v1). It reports false if there is no quota or it can't be read.
+func cpuQuota(root string) (float64, bool) {
+	if max, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(max))
+		if len(fields) != 2 {
+			return 0, false
+		}
+
+		return quota(fields[0], fields[1])
+	}
+
+	quotaUS, err := ioutil.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+
+	periodUS, err := ioutil.ReadFile(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+
+	return quota(strings.TrimSpace(string(quotaUS)), strings.TrimSpace(string(periodUS)))
+}
+
+func quota(quotaUS, periodUS string) (float64, bool) {
+	// "max" (v2) and -1 (v1) mean no quota
+	q, err := strconv.ParseFloat(quotaUS, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+
+	p, err := strconv.ParseFloat(periodUS, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+
+	return q / p, true
+}
@@ -0,0 +1,75 @@
+package gometrics
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestCPUQuota(t *testing.T) {
+	v2 := t.TempDir()
+	writeFile(t, filepath.Join(v2, "cpu.max"), "150000 100000\n")
+
+	v2Unlimited := t.TempDir()
+	writeFile(t, filepath.Join(v2Unlimited, "cpu.max"), "max 100000\n")
+
+	v1 := t.TempDir()
+	writeFile(t, filepath.Join(v1, "cpu", "cpu.cfs_quota_us"), "50000\n")
+	writeFile(t, filepath.Join(v1, "cpu", "cpu.cfs_period_us"), "100000\n")
+
+	v1Unlimited := t.TempDir()
+	writeFile(t, filepath.Join(v1Unlimited, "cpu", "cpu.cfs_quota_us"), "-1\n")
+	writeFile(t, filepath.Join(v1Unlimited, "cpu", "cpu.cfs_period_us"), "100000\n")
+
+	for _, tc := range []struct {
+		name string
+		root string
+		cpus float64
+		ok   bool
+	}{
+		{"v2", v2, 1.5, true},
+		{"v2 unlimited", v2Unlimited, 0, false},
+		{"v1", v1, 0.5, true},
+		{"v1 unlimited", v1Unlimited, 0, false},
+		{"missing", t.TempDir(), 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cpus, ok := cpuQuota(tc.root)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.cpus, cpus)
+		})
+	}
+}
+
+func TestCollectGOMAXPROCSAndCPUQuota(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "cpu.max"), "200000 100000\n")
+
+	defer func(prev string) { cgroupRoot = prev }(cgroupRoot)
+	cgroupRoot = root
+
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectGOMAXPROCS = true
+	m.CollectCPUQuota = true
+
+	var stats Stats
+
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"GOMAXPROCS", "CPUQuota"}, cwAPI.names)
+	assert.Equal(t, []float64{float64(runtime.GOMAXPROCS(0)), 2}, cwAPI.values)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package gometrics
+
+import "time"
+
+// processCPUTime reports that the CPU time of the process isn't available on
+// this platform.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
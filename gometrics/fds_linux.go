@@ -0,0 +1,20 @@
+package gometrics
+
+import "os"
+
+// openFDs returns the number of file descriptors the process has open.
+func openFDs() (int, bool) {
+	dir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, false
+	}
+
+	// the descriptor of the directory being read is listed as well
+	return len(names) - 1, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package gometrics
+
+// openFDs reports that the number of open file descriptors isn't available on
+// this platform.
+func openFDs() (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,48 @@
+package gometrics
+
+import (
+	"math"
+	"runtime"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+func (m *GoMetrics) collectGCPauses(stats *runtime.MemStats) {
+	// the number of GCs is tracked even while the metric is disabled so that
+	// enabling it only considers the pauses that happen afterwards
+	last := m.state().lastNumGC
+	m.state().lastNumGC = stats.NumGC
+
+	// a decreased number has been reset, e.g. because the process the Source
+	// reads from restarted, the interval is skipped then
+	if stats.NumGC < last {
+		return
+	}
+
+	n := stats.NumGC - last
+
+	if n == 0 || !m.collects("GCPausePercentiles", m.CollectGCPausePercentiles) {
+		return
+	}
+
+	if n > uint32(len(stats.PauseNs)) {
+		n = uint32(len(stats.PauseNs))
+	}
+
+	pauses := make([]uint64, n)
+	for i := range pauses {
+		pauses[i] = stats.PauseNs[(stats.NumGC-uint32(i)+uint32(len(stats.PauseNs))-1)%uint32(len(stats.PauseNs))]
+	}
+
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i] < pauses[j] })
+
+	for _, p := range runtimePercentiles {
+		rank := int(math.Ceil(p.p*float64(len(pauses)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+
+		m.add(true, "GCPause"+p.suffix, float64(pauses[rank])/1000, cloudwatch.StandardUnitMicroseconds)
+	}
+}
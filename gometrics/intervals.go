@@ -0,0 +1,94 @@
+package gometrics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/molecule-man/cwatsch"
+)
+
+// intervalState is the state of the collections made on one interval. The
+// metrics computed relative to the previous collection (the *PerInterval,
+// CPUPercent and percentile metrics) are computed from it, so that they cover
+// their own interval.
+type intervalState struct {
+	interval  time.Duration
+	cpu       cpuSample
+	lastNumGC uint32
+	prevStats deltaStats
+	rtMetrics runtimeState
+}
+
+// launchIntervals returns the intervals of Intervals that differ from the one
+// given to Launch, in ascending order.
+func (m *GoMetrics) launchIntervals() []time.Duration {
+	seen := map[time.Duration]bool{}
+
+	var intervals []time.Duration
+
+	for _, d := range m.Intervals {
+		if d > 0 && d != m.interval && !seen[d] {
+			seen[d] = true
+			intervals = append(intervals, d)
+		}
+	}
+
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] < intervals[j] })
+
+	return intervals
+}
+
+// run collects the metrics due on the interval of the state until ctx is
+// done. The runs of all the intervals share the batch.
+func (m *GoMetrics) run(ctx context.Context, state *intervalState, interval time.Duration) {
+	var stats Stats
+
+	cwatsch.NewTicker(ctx, interval, func() {
+		m.collectOn(state, &stats)
+
+		err := m.batch.FlushCompleteBatchesCtx(ctx)
+		if err != nil && m.OnError != nil {
+			m.OnError(err)
+		}
+	})
+}
+
+// runAll runs the collections of all the intervals and waits for them to
+// stop.
+func (m *GoMetrics) runAll(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+
+	for _, d := range m.launchIntervals() {
+		wg.Add(1)
+
+		go func(d time.Duration) {
+			defer wg.Done()
+			m.run(ctx, &intervalState{interval: d}, d)
+		}(d)
+	}
+
+	m.run(ctx, &m.intervalState, interval)
+	wg.Wait()
+}
+
+// due reports whether the metric is collected on the interval currently being
+// collected.
+func (m *GoMetrics) due(name string) bool {
+	d, ok := m.Intervals[name]
+	if !ok || d <= 0 || d == m.interval {
+		return m.state() == &m.intervalState
+	}
+
+	return m.state().interval == d
+}
+
+// state returns the state of the collection in progress.
+func (m *GoMetrics) state() *intervalState {
+	if m.current == nil {
+		return &m.intervalState
+	}
+
+	return m.current
+}
@@ -0,0 +1,190 @@
+package gometrics
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// MetadataResolver detects the dimensions describing the host the process runs
+// on. See NewWithResolvers.
+type MetadataResolver interface {
+	// Available reports whether the resolver can be used on this host.
+	Available() bool
+	// Dimensions returns the dimensions describing the host.
+	Dimensions() ([]*cloudwatch.Dimension, error)
+}
+
+// EC2Resolver resolves the InstanceID and AZ dimensions from the EC2 instance
+// metadata service.
+type EC2Resolver struct {
+	client *ec2metadata.EC2Metadata
+}
+
+// NewEC2Resolver creates EC2Resolver.
+func NewEC2Resolver(cfg client.ConfigProvider) *EC2Resolver {
+	return &EC2Resolver{client: ec2metadata.New(cfg)}
+}
+
+func (r *EC2Resolver) Available() bool {
+	return r.client.Available()
+}
+
+func (r *EC2Resolver) Dimensions() ([]*cloudwatch.Dimension, error) {
+	metadata, err := r.client.GetInstanceIdentityDocument()
+	if err != nil {
+		return nil, err
+	}
+
+	return []*cloudwatch.Dimension{
+		{Name: aws.String("InstanceID"), Value: aws.String(metadata.InstanceID)},
+		{Name: aws.String("AZ"), Value: aws.String(metadata.AvailabilityZone)},
+	}, nil
+}
+
+// GCEResolver resolves the InstanceID and AZ dimensions from the GCE metadata
+// server. The zone is reported as AZ, so the metrics of the instances running
+// on EC2 and GCE share the dimension names.
+type GCEResolver struct {
+	host   string
+	client http.Client
+}
+
+// NewGCEResolver creates GCEResolver. The metadata server address can be
+// overridden by the GCE_METADATA_HOST environment variable.
+func NewGCEResolver() *GCEResolver {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+
+	return &GCEResolver{
+		host:   "http://" + host,
+		client: http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+func (r *GCEResolver) Available() bool {
+	resp, err := r.get("/")
+	if err != nil {
+		return false
+	}
+
+	defer resp.Body.Close()
+
+	return resp.Header.Get("Metadata-Flavor") == "Google"
+}
+
+func (r *GCEResolver) Dimensions() ([]*cloudwatch.Dimension, error) {
+	id, err := r.read("/computeMetadata/v1/instance/id")
+	if err != nil {
+		return nil, err
+	}
+
+	// the zone is returned as projects/<project number>/zones/<zone>
+	zone, err := r.read("/computeMetadata/v1/instance/zone")
+	if err != nil {
+		return nil, err
+	}
+
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+
+	return []*cloudwatch.Dimension{
+		{Name: aws.String("InstanceID"), Value: aws.String(id)},
+		{Name: aws.String("AZ"), Value: aws.String(zone)},
+	}, nil
+}
+
+func (r *GCEResolver) get(path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, r.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	return r.client.Do(req)
+}
+
+func (r *GCEResolver) read(path string) (string, error) {
+	resp, err := r.get(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &metadataError{path: path, status: resp.Status}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
+
+type metadataError struct {
+	path   string
+	status string
+}
+
+func (e *metadataError) Error() string {
+	return "gometrics: reading metadata " + e.path + ": " + e.status
+}
+
+// HostnameResolver resolves the Host dimension from the host name reported by
+// the kernel. It's meant as the last resort for hosts without a metadata
+// service, e.g. on premises.
+type HostnameResolver struct{}
+
+func (HostnameResolver) Available() bool {
+	name, err := os.Hostname()
+	return err == nil && name != ""
+}
+
+func (HostnameResolver) Dimensions() ([]*cloudwatch.Dimension, error) {
+	name, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return []*cloudwatch.Dimension{{Name: aws.String("Host"), Value: aws.String(name)}}, nil
+}
+
+// ErrNoMetadata is returned by NewWithError if there is no metadata service
+// the dimensions describing the host could be detected from.
+var ErrNoMetadata = errors.New("gometrics: no metadata service available")
+
+// resolveDimensions appends the dimensions of the first available resolver. If
+// the resolver fails, the others aren't tried and no dimensions are appended.
+// ErrNoMetadata is returned if none of the resolvers is available.
+func (m *GoMetrics) resolveDimensions(resolvers []MetadataResolver) error {
+	for _, r := range resolvers {
+		if !r.Available() {
+			continue
+		}
+
+		dims, err := r.Dimensions()
+		if err != nil {
+			return fmt.Errorf("gometrics: resolving dimensions: %w", err)
+		}
+
+		m.Dimensions = append(m.Dimensions, dims...)
+
+		return nil
+	}
+
+	return ErrNoMetadata
+}
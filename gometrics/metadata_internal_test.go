@@ -0,0 +1,206 @@
+package gometrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGCEResolver(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Metadata-Flavor", "Google")
+
+		switch r.URL.Path {
+		case "/":
+		case "/computeMetadata/v1/instance/id":
+			_, _ = w.Write([]byte("4520031799277581759"))
+		case "/computeMetadata/v1/instance/zone":
+			_, _ = w.Write([]byte("projects/123456789/zones/us-central1-a"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r := NewGCEResolver()
+	r.host = srv.URL
+
+	require.True(t, r.Available())
+
+	dims, err := r.Dimensions()
+	require.NoError(t, err)
+	assert.Equal(t, []*cloudwatch.Dimension{
+		{Name: aws.String("InstanceID"), Value: aws.String("4520031799277581759")},
+		{Name: aws.String("AZ"), Value: aws.String("us-central1-a")},
+	}, dims)
+}
+
+func TestGCEResolverNotOnGCE(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	r := NewGCEResolver()
+	r.host = srv.URL
+
+	assert.False(t, r.Available())
+}
+
+type fakeResolver struct {
+	available bool
+	dims      []*cloudwatch.Dimension
+	err       error
+}
+
+func (r fakeResolver) Available() bool { return r.available }
+
+func (r fakeResolver) Dimensions() ([]*cloudwatch.Dimension, error) { return r.dims, r.err }
+
+func TestResolveDimensions(t *testing.T) {
+	dim := func(name string) []*cloudwatch.Dimension {
+		return []*cloudwatch.Dimension{{Name: aws.String(name), Value: aws.String("v")}}
+	}
+
+	m := &GoMetrics{}
+	err := m.resolveDimensions([]MetadataResolver{
+		fakeResolver{available: false, dims: dim("first")},
+		fakeResolver{available: true, dims: dim("second")},
+		fakeResolver{available: true, dims: dim("third")},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, dim("second"), m.Dimensions)
+
+	failure := errors.New("failed")
+
+	m = &GoMetrics{}
+	err = m.resolveDimensions([]MetadataResolver{
+		fakeResolver{available: true, err: failure},
+		fakeResolver{available: true, dims: dim("second")},
+	})
+	assert.True(t, errors.Is(err, failure))
+	assert.Empty(t, m.Dimensions)
+
+	m = &GoMetrics{}
+	err = m.resolveDimensions([]MetadataResolver{fakeResolver{available: false, dims: dim("first")}})
+	assert.Equal(t, ErrNoMetadata, err)
+	assert.Empty(t, m.Dimensions)
+
+	m = &GoMetrics{}
+	require.NoError(t, m.resolveDimensions([]MetadataResolver{HostnameResolver{}}))
+	require.Len(t, m.Dimensions, 1)
+	assert.Equal(t, "Host", aws.StringValue(m.Dimensions[0].Name))
+}
+
+func TestNewWithError(t *testing.T) {
+	setenv(t, "AWS_EC2_METADATA_DISABLED", "true")
+	setenv(t, "ECS_CONTAINER_METADATA_URI", "")
+	setenv(t, "ECS_CONTAINER_METADATA_URI_V4", "")
+
+	cfg := session.Must(session.NewSession(aws.NewConfig().WithRegion("eu-west-1")))
+
+	m, err := NewWithError(cfg)
+	require.NotNil(t, m)
+	assert.Equal(t, ErrNoMetadata, err)
+	assert.Empty(t, m.Dimensions)
+
+	m, err = NewWithError(cfg, fakeResolver{available: true, dims: []*cloudwatch.Dimension{
+		{Name: aws.String("Host"), Value: aws.String("h")},
+	}})
+	require.NoError(t, err)
+	assert.Len(t, m.Dimensions, 1, "the given resolvers are used")
+
+	failure := errors.New("failed")
+	_, err = NewWithError(cfg, fakeResolver{available: true, err: failure})
+	assert.True(t, errors.Is(err, failure))
+
+	setenv(t, "ECS_CONTAINER_METADATA_URI_V4", "http://127.0.0.1:1/v4")
+
+	_, err = NewWithError(cfg)
+	require.Error(t, err, "the failure of the ECS detection is reported")
+	assert.Contains(t, err.Error(), "ECS metadata")
+	assert.False(t, errors.Is(err, ErrNoMetadata), "ECS counts as a metadata service")
+}
+
+func TestECSDimensions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v4":
+			_, _ = w.Write([]byte(`{"DockerId": "cont-1", "Name": "app"}`))
+		case "/v4/task":
+			_, _ = w.Write([]byte(`{
+				"Cluster": "arn:aws:ecs:us-west-2:111122223333:cluster/default",
+				"TaskARN": "arn:aws:ecs:us-west-2:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c",
+				"ServiceName": "web"
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	setenv(t, "ECS_CONTAINER_METADATA_URI", srv.URL+"/v3")
+	setenv(t, "ECS_CONTAINER_METADATA_URI_V4", srv.URL+"/v4")
+
+	m := &GoMetrics{}
+	require.NoError(t, m.determineECSDimenstions(time.Second))
+
+	assert.Equal(t, []*cloudwatch.Dimension{
+		{Name: aws.String("ContainerID"), Value: aws.String("cont-1")},
+		{Name: aws.String("Cluster"), Value: aws.String("arn:aws:ecs:us-west-2:111122223333:cluster/default")},
+		{Name: aws.String("TaskARN"), Value: aws.String("arn:aws:ecs:us-west-2:111122223333:task/default/158d1c8083dd49d6b527399fd6414f5c")},
+		{Name: aws.String("ServiceName"), Value: aws.String("web")},
+	}, m.Dimensions)
+
+	cfg := session.Must(session.NewSession(aws.NewConfig().WithRegion("eu-west-1")))
+	assert.Equal(t, m.Dimensions, NewWithResolvers(cfg).Dimensions, "the dimensions are detected on creation")
+}
+
+func TestECSDimensionsTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	setenv(t, "ECS_CONTAINER_METADATA_URI_V4", srv.URL)
+
+	m := &GoMetrics{}
+	assert.Error(t, m.determineECSDimenstions(10*time.Millisecond))
+	assert.Empty(t, m.Dimensions)
+
+	cfg := session.Must(session.NewSession(aws.NewConfig().WithRegion("eu-west-1")))
+	started := time.Now()
+
+	m, err := NewWithError(cfg, HostnameResolver{}, ECSTimeout(10*time.Millisecond))
+	require.Error(t, err)
+	assert.True(t, time.Since(started) < time.Second, "the timeout is given per collector")
+	require.Len(t, m.Dimensions, 1, "ECSTimeout isn't a resolver")
+	assert.Equal(t, "Host", aws.StringValue(m.Dimensions[0].Name))
+}
+
+func setenv(t *testing.T, key, value string) {
+	prev, ok := os.LookupEnv(key)
+	require.NoError(t, os.Setenv(key, value))
+
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
@@ -3,14 +3,16 @@ package gometrics
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
-	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/molecule-man/cwatsch"
 )
@@ -18,20 +20,127 @@ import (
 // New creates collector of go metrics. Upon creation enable required metrics by
 // toggling appropriate GoMetrics.Collect* fields.
 func New(cfg client.ConfigProvider) *GoMetrics {
+	return NewWithResolvers(cfg, NewEC2Resolver(cfg))
+}
+
+// NewWithResolvers creates collector of go metrics the same way New does, but
+// detects the dimensions describing the host with the given resolvers instead
+// of the EC2 metadata service. The resolvers are tried in order and the first
+// available one wins, e.g.:
+//
+//	gometrics.NewWithResolvers(cfg,
+//		gometrics.NewEC2Resolver(cfg),
+//		gometrics.NewGCEResolver(),
+//		gometrics.HostnameResolver{},
+//	)
+//
+// The requests to the ECS container metadata endpoint are limited by passing
+// ECSTimeout among the resolvers.
+func NewWithResolvers(cfg client.ConfigProvider, resolvers ...MetadataResolver) *GoMetrics {
+	goMetrics, _ := newGoMetrics(cfg, resolvers)
+
+	return goMetrics
+}
+
+// NewWithError creates collector of go metrics the same way NewWithResolvers
+// does (with the EC2 resolver if no resolvers are given), but returns the
+// errors that prevented the dimensions describing the host from being
+// detected: the error of reading the ECS container metadata, the error of the
+// resolver, or ErrNoMetadata if neither the ECS metadata nor any of the
+// resolvers is available. The returned collector is usable either way, it
+// just lacks the dimensions.
+func NewWithError(cfg client.ConfigProvider, resolvers ...MetadataResolver) (*GoMetrics, error) {
+	ecsTimeout, resolvers := splitECSTimeout(resolvers)
+	if len(resolvers) == 0 {
+		resolvers = []MetadataResolver{NewEC2Resolver(cfg)}
+	}
+
+	return newGoMetrics(cfg, append(resolvers, ecsTimeout))
+}
+
+func newGoMetrics(cfg client.ConfigProvider, resolvers []MetadataResolver) (*GoMetrics, error) {
 	goMetrics := &GoMetrics{
 		Namespace: "gometrics",
 		batch:     cwatsch.New(cloudwatch.New(cfg)),
 	}
-	goMetrics.determineECSDimenstions()
-	goMetrics.determineEC2Dimenstions(cfg)
 
-	return goMetrics
+	ecsTimeout, resolvers := splitECSTimeout(resolvers)
+	ecsErr := goMetrics.determineECSDimenstions(time.Duration(ecsTimeout))
+
+	err := goMetrics.resolveDimensions(resolvers)
+	if errors.Is(err, ErrNoMetadata) && ecsMetadataURI() != "" {
+		err = nil
+	}
+
+	switch {
+	case ecsErr == nil:
+		return goMetrics, err
+	case err == nil:
+		return goMetrics, ecsErr
+	default:
+		return goMetrics, errors.Join(ecsErr, err)
+	}
+}
+
+// ECSTimeout limits the requests to the ECS container metadata endpoint made
+// while the collector is created, 2 seconds by default. It's passed to
+// NewWithResolvers or NewWithError along with the resolvers, e.g.:
+//
+//	gometrics.NewWithResolvers(cfg, gometrics.NewEC2Resolver(cfg), gometrics.ECSTimeout(time.Second))
+//
+// It isn't a resolver on its own, it's never available.
+type ECSTimeout time.Duration
+
+const defaultECSTimeout = ECSTimeout(2 * time.Second)
+
+func (ECSTimeout) Available() bool {
+	return false
+}
+
+func (ECSTimeout) Dimensions() ([]*cloudwatch.Dimension, error) {
+	return nil, ErrNoMetadata
+}
+
+// splitECSTimeout takes the ECS timeout out of the resolvers. The last one
+// given wins.
+func splitECSTimeout(resolvers []MetadataResolver) (ECSTimeout, []MetadataResolver) {
+	timeout := defaultECSTimeout
+	rest := make([]MetadataResolver, 0, len(resolvers))
+
+	for _, r := range resolvers {
+		if t, ok := r.(ECSTimeout); ok {
+			timeout = t
+			continue
+		}
+
+		rest = append(rest, r)
+	}
+
+	return timeout, rest
 }
 
 type GoMetrics struct {
 	Dimensions []*cloudwatch.Dimension
-	Namespace  string
+	// DimensionProvider, if set, is called on every collection and the
+	// dimensions it returns are attached to the collected metrics in addition
+	// to Dimensions (the ones detected automatically on EC2 and ECS). A
+	// provided dimension replaces the one in Dimensions with the same name.
+	// It's meant for dimensions that aren't known upfront or may change, e.g.
+	// the pod name on Kubernetes.
+	DimensionProvider func() []*cloudwatch.Dimension
+	Namespace         string
+	// NamePrefix and NameSuffix are added to the names of all the metrics
+	// sent, e.g. the prefix "api_" makes HeapAlloc be sent as api_HeapAlloc.
+	// They tell apart the services publishing to the same namespace. The
+	// names used by SetCollect and Intervals stay unprefixed.
+	NamePrefix string
+	NameSuffix string
 	OnError    func(error)
+	// StorageResolution is the storage resolution of the collected metrics.
+	// Set it to 1 (together with a Launch interval below a minute) to collect
+	// high-resolution metrics. See cwatsch.Batch.AddHighRes for the cost
+	// implications.
+	StorageResolution int64
 
 	CollectTotalAlloc    bool
 	CollectSys           bool
@@ -59,114 +168,465 @@ type GoMetrics struct {
 	CollectNumForcedGC   bool
 	CollectGCCPUFraction bool
 	CollectNumGoroutine  bool
+	// CollectCPUPercent enables the CPUPercent metric: the CPU time consumed by
+	// the process between two collections relative to the capacity of all the
+	// CPUs of the machine. The first collection only takes the initial sample.
+	// The metric is skipped on platforms where the CPU time of the process
+	// isn't available.
+	CollectCPUPercent bool
+	// CollectOpenFDs enables the OpenFDs metric: the number of file descriptors
+	// the process has open, read from /proc/self/fd. A steadily growing count
+	// points to a descriptor leak. The metric is skipped on platforms other
+	// than Linux.
+	CollectOpenFDs bool
+	// CollectProcessRSS and CollectProcessVSZ enable the ProcessRSS and
+	// ProcessVSZ metrics: the resident set size and the virtual memory size of
+	// the whole process, read from /proc/self/statm. Unlike the MemStats they
+	// include the memory not managed by Go. The metrics are skipped on
+	// platforms other than Linux.
+	CollectProcessRSS bool
+	CollectProcessVSZ bool
+	// CollectGOMAXPROCS enables the GOMAXPROCS metric: the number of CPUs the
+	// Go scheduler uses. CollectCPUQuota enables the CPUQuota metric: the
+	// number of CPUs the container may use, read from the cgroup (v1 or v2)
+	// CPU quota. Comparing the two reveals misconfigured CPU limits, e.g.
+	// GOMAXPROCS exceeding the quota leads to throttling. CPUQuota is skipped
+	// if there is no quota or it can't be read.
+	CollectGOMAXPROCS bool
+	CollectCPUQuota   bool
+	// CollectGCPausePercentiles enables the GCPauseP50, GCPauseP90 and
+	// GCPauseP99 metrics: the percentiles of the GC pauses that happened since
+	// the previous collection, read from MemStats.PauseNs. Since the runtime
+	// only keeps the 256 most recent pauses, older ones are missed if more GCs
+	// happen within a single interval. Nothing is emitted for intervals without
+	// GC.
+	CollectGCPausePercentiles bool
+
+	// The *PerInterval metrics are the increase of the corresponding
+	// cumulative counters since the previous collection, so they can be
+	// graphed and alarmed on without applying RATE() in CloudWatch. They can be
+	// collected alongside or instead of the cumulative values, e.g.
+	// NumGCPerInterval is the GC frequency. The first collection only takes
+	// the initial sample, and an interval in which a counter has been reset
+	// is skipped.
+	CollectTotalAllocPerInterval   bool
+	CollectLookupsPerInterval      bool
+	CollectMallocsPerInterval      bool
+	CollectFreesPerInterval        bool
+	CollectPauseTotalNsPerInterval bool
+	CollectNumGCPerInterval        bool
+	CollectNumForcedGCPerInterval  bool
+	// UseRuntimeMetrics enables the metrics read from the runtime/metrics
+	// package: the 50th, 90th and 99th percentiles of the GC pauses
+	// (GCPausesP50, GCPausesP90, GCPausesP99) and of the time goroutines spend
+	// runnable before they get to run (SchedLatenciesP50 etc.) observed since
+	// the previous collection. The metrics are collected in addition to the
+	// ones enabled by the Collect* fields. Use SetCollect with the names of the
+	// individual metrics to disable some of them.
+	UseRuntimeMetrics bool
+	// Intervals overrides the collection interval of individual metrics, keyed
+	// by the name of the metric as it's sent (e.g. "NumGoroutine" or
+	// "GCPauseP99"). Launch runs a ticker for every distinct interval, the
+	// metrics not listed are collected on the interval given to Launch. Slowly
+	// changing metrics can be collected less often this way, which saves on
+	// the datapoints sent. Like the Collect* fields, it must be set before
+	// Launch.
+	Intervals map[string]time.Duration
+	// Source provides the statistics the MemStats based metrics and
+	// NumGoroutine are computed from, e.g. ones scraped from another process.
+	// Defaults to RuntimeSource, the running process. The other metrics
+	// (CPUPercent, OpenFDs, ProcessRSS, ProcessVSZ and the runtime metrics)
+	// always describe the running process. If reading the statistics fails,
+	// the error is passed to OnError and the collection is skipped.
+	Source StatsSource
+
+	batch    *cwatsch.Batch
+	toggles  sync.Map
+	dims     []*cloudwatch.Dimension
+	interval time.Duration
+	// intervalState is the state of the collections on the interval given to
+	// Launch, current the one of the collection in progress.
+	intervalState
+	current   *intervalState
+	collectMu sync.Mutex
+}
+
+type cpuSample struct {
+	used time.Duration
+	at   time.Time
+}
+
+// SetCollect enables or disables collection of the metric with the given name
+// (e.g. "HeapAlloc" for CollectHeapAlloc). Unlike assigning the Collect*
+// fields, which must be done before Launch, it's safe to call while the
+// collection is running. The value set here takes precedence over the
+// corresponding Collect* field.
+func (m *GoMetrics) SetCollect(name string, enabled bool) {
+	m.toggles.Store(name, enabled)
+}
+
+// Enable enables collection of the metrics with the given names the same way
+// SetCollect does, e.g. to drive the configuration from a list in a config
+// file.
+func (m *GoMetrics) Enable(names ...string) {
+	for _, name := range names {
+		m.SetCollect(name, true)
+	}
+}
 
-	batch *cwatsch.Batch
+// CollectAll sets all the Collect* fields. Like assigning the fields, it must
+// be done before Launch.
+func (m *GoMetrics) CollectAll() {
+	for _, f := range m.collectFields() {
+		*f = true
+	}
+}
+
+// CollectNone clears all the Collect* fields. Like assigning the fields, it
+// must be done before Launch. The metrics enabled with SetCollect or Enable
+// are still collected.
+func (m *GoMetrics) CollectNone() {
+	for _, f := range m.collectFields() {
+		*f = false
+	}
+}
+
+func (m *GoMetrics) collectFields() []*bool {
+	return []*bool{
+		&m.CollectTotalAlloc, &m.CollectSys, &m.CollectLookups, &m.CollectMallocs,
+		&m.CollectFrees, &m.CollectHeapAlloc, &m.CollectHeapSys, &m.CollectHeapIdle,
+		&m.CollectHeapInuse, &m.CollectHeapReleased, &m.CollectHeapObjects,
+		&m.CollectStackInuse, &m.CollectStackSys, &m.CollectMSpanInuse,
+		&m.CollectMSpanSys, &m.CollectMCacheInuse, &m.CollectMCacheSys,
+		&m.CollectBuckHashSys, &m.CollectGCSys, &m.CollectNextGC, &m.CollectLastGC,
+		&m.CollectPauseTotalNs, &m.CollectNumGC, &m.CollectNumForcedGC,
+		&m.CollectGCCPUFraction, &m.CollectNumGoroutine, &m.CollectCPUPercent,
+		&m.CollectOpenFDs, &m.CollectProcessRSS, &m.CollectProcessVSZ,
+		&m.CollectGOMAXPROCS, &m.CollectCPUQuota,
+		&m.CollectGCPausePercentiles,
+		&m.CollectTotalAllocPerInterval, &m.CollectLookupsPerInterval,
+		&m.CollectMallocsPerInterval, &m.CollectFreesPerInterval,
+		&m.CollectPauseTotalNsPerInterval, &m.CollectNumGCPerInterval,
+		&m.CollectNumForcedGCPerInterval,
+	}
 }
 
+func (m *GoMetrics) collects(name string, enabled bool) bool {
+	if v, ok := m.toggles.Load(name); ok {
+		return v.(bool)
+	}
+
+	return enabled
+}
+
+// finalFlushTimeout limits the flush Launch performs when its context is
+// cancelled.
+const finalFlushTimeout = 5 * time.Second
+
 // Launch starts metric collection which is executed periodically in intervals
-// specified by the the second argument.
+// specified by the the second argument. When ctx is cancelled, the metrics
+// collected but not sent yet are flushed (giving up after 5 seconds) before
+// Launch returns.
 func (m *GoMetrics) Launch(ctx context.Context, interval time.Duration) {
-	var stats runtime.MemStats
-
-	cwatsch.NewTicker(ctx, interval, func() {
-		runtime.ReadMemStats(&stats)
-
-		m.add(m.CollectTotalAlloc, "TotalAlloc", float64(stats.TotalAlloc), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectSys, "Sys", float64(stats.Sys), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectLookups, "Lookups", float64(stats.Lookups), cloudwatch.StandardUnitCount)
-		m.add(m.CollectMallocs, "Mallocs", float64(stats.Mallocs), cloudwatch.StandardUnitCount)
-		m.add(m.CollectFrees, "Frees", float64(stats.Frees), cloudwatch.StandardUnitCount)
-		m.add(m.CollectHeapAlloc, "HeapAlloc", float64(stats.HeapAlloc), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectHeapSys, "HeapSys", float64(stats.HeapSys), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectHeapIdle, "HeapIdle", float64(stats.HeapIdle), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectHeapInuse, "HeapInuse", float64(stats.HeapInuse), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectHeapReleased, "HeapReleased", float64(stats.HeapReleased), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectHeapObjects, "HeapObjects", float64(stats.HeapObjects), cloudwatch.StandardUnitCount)
-		m.add(m.CollectStackInuse, "StackInuse", float64(stats.StackInuse), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectStackSys, "StackSys", float64(stats.StackSys), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectMSpanInuse, "MSpanInuse", float64(stats.MSpanInuse), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectMSpanSys, "MSpanSys", float64(stats.MSpanSys), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectMCacheInuse, "MCacheInuse", float64(stats.MCacheInuse), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectMCacheSys, "MCacheSys", float64(stats.MCacheSys), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectBuckHashSys, "BuckHashSys", float64(stats.BuckHashSys), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectGCSys, "GCSys", float64(stats.GCSys), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectNextGC, "NextGC", float64(stats.NextGC), cloudwatch.StandardUnitBytes)
-		m.add(m.CollectLastGC, "LastGC", float64(stats.LastGC)/1000, cloudwatch.StandardUnitMicroseconds)
-		m.add(m.CollectPauseTotalNs, "PauseTotalNs", float64(stats.PauseTotalNs)/1000, cloudwatch.StandardUnitMicroseconds)
-		m.add(m.CollectNumGC, "NumGC", float64(stats.NumGC), cloudwatch.StandardUnitCount)
-		m.add(m.CollectNumForcedGC, "NumForcedGC", float64(stats.NumForcedGC), cloudwatch.StandardUnitCount)
-		m.add(m.CollectGCCPUFraction, "GCCPUFraction", 100.0*stats.GCCPUFraction, cloudwatch.StandardUnitPercent)
-		m.add(m.CollectNumGoroutine, "NumGoroutine", float64(runtime.NumGoroutine()), cloudwatch.StandardUnitCount)
-
-		err := m.batch.FlushCompleteBatchesCtx(ctx)
-		if err != nil && m.OnError != nil {
+	m.interval = interval
+	m.runAll(ctx, interval)
+
+	if err := m.batch.FlushWithTimeout(finalFlushTimeout); err != nil && m.OnError != nil {
+		m.OnError(err)
+	}
+}
+
+func (m *GoMetrics) collect(stats *Stats) {
+	m.collectOn(&m.intervalState, stats)
+}
+
+// collectOn collects the metrics due on the interval of the state.
+func (m *GoMetrics) collectOn(state *intervalState, stats *Stats) {
+	m.collectMu.Lock()
+	defer m.collectMu.Unlock()
+
+	m.current = state
+	m.dims = m.dimensions()
+
+	if err := m.source().Read(stats); err != nil {
+		if m.OnError != nil {
 			m.OnError(err)
 		}
-	})
+
+		return
+	}
+
+	m.add(m.CollectTotalAlloc, "TotalAlloc", float64(stats.TotalAlloc), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectSys, "Sys", float64(stats.Sys), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectLookups, "Lookups", float64(stats.Lookups), cloudwatch.StandardUnitCount)
+	m.add(m.CollectMallocs, "Mallocs", float64(stats.Mallocs), cloudwatch.StandardUnitCount)
+	m.add(m.CollectFrees, "Frees", float64(stats.Frees), cloudwatch.StandardUnitCount)
+	m.add(m.CollectHeapAlloc, "HeapAlloc", float64(stats.HeapAlloc), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectHeapSys, "HeapSys", float64(stats.HeapSys), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectHeapIdle, "HeapIdle", float64(stats.HeapIdle), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectHeapInuse, "HeapInuse", float64(stats.HeapInuse), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectHeapReleased, "HeapReleased", float64(stats.HeapReleased), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectHeapObjects, "HeapObjects", float64(stats.HeapObjects), cloudwatch.StandardUnitCount)
+	m.add(m.CollectStackInuse, "StackInuse", float64(stats.StackInuse), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectStackSys, "StackSys", float64(stats.StackSys), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectMSpanInuse, "MSpanInuse", float64(stats.MSpanInuse), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectMSpanSys, "MSpanSys", float64(stats.MSpanSys), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectMCacheInuse, "MCacheInuse", float64(stats.MCacheInuse), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectMCacheSys, "MCacheSys", float64(stats.MCacheSys), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectBuckHashSys, "BuckHashSys", float64(stats.BuckHashSys), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectGCSys, "GCSys", float64(stats.GCSys), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectNextGC, "NextGC", float64(stats.NextGC), cloudwatch.StandardUnitBytes)
+	m.add(m.CollectLastGC, "LastGC", float64(stats.LastGC)/1000, cloudwatch.StandardUnitMicroseconds)
+	m.add(m.CollectPauseTotalNs, "PauseTotalNs", float64(stats.PauseTotalNs)/1000, cloudwatch.StandardUnitMicroseconds)
+	m.add(m.CollectNumGC, "NumGC", float64(stats.NumGC), cloudwatch.StandardUnitCount)
+	m.add(m.CollectNumForcedGC, "NumForcedGC", float64(stats.NumForcedGC), cloudwatch.StandardUnitCount)
+	m.add(m.CollectGCCPUFraction, "GCCPUFraction", 100.0*stats.GCCPUFraction, cloudwatch.StandardUnitPercent)
+	m.add(m.CollectNumGoroutine, "NumGoroutine", float64(stats.NumGoroutine), cloudwatch.StandardUnitCount)
+	m.collectDeltas(&stats.MemStats)
+	m.collectGCPauses(&stats.MemStats)
+	m.collectCPU()
+	m.collectOpenFDs()
+	m.collectProcessMemory()
+	m.add(m.CollectGOMAXPROCS, "GOMAXPROCS", float64(runtime.GOMAXPROCS(0)), cloudwatch.StandardUnitCount)
+	m.collectCPUQuota()
+	m.collectRuntimeMetrics()
 }
+
+func (m *GoMetrics) collectDeltas(stats *runtime.MemStats) {
+	prev := m.state().prevStats
+	m.state().prevStats = deltaStats{
+		TotalAlloc:   stats.TotalAlloc,
+		Lookups:      stats.Lookups,
+		Mallocs:      stats.Mallocs,
+		Frees:        stats.Frees,
+		PauseTotalNs: stats.PauseTotalNs,
+		NumGC:        uint64(stats.NumGC),
+		NumForcedGC:  uint64(stats.NumForcedGC),
+		valid:        true,
+	}
+
+	if !prev.valid {
+		return
+	}
+
+	cur := m.state().prevStats
+
+	m.addDelta(m.CollectTotalAllocPerInterval, "TotalAllocPerInterval", cur.TotalAlloc, prev.TotalAlloc, 1, cloudwatch.StandardUnitBytes)
+	m.addDelta(m.CollectLookupsPerInterval, "LookupsPerInterval", cur.Lookups, prev.Lookups, 1, cloudwatch.StandardUnitCount)
+	m.addDelta(m.CollectMallocsPerInterval, "MallocsPerInterval", cur.Mallocs, prev.Mallocs, 1, cloudwatch.StandardUnitCount)
+	m.addDelta(m.CollectFreesPerInterval, "FreesPerInterval", cur.Frees, prev.Frees, 1, cloudwatch.StandardUnitCount)
+	m.addDelta(m.CollectPauseTotalNsPerInterval, "PauseTotalNsPerInterval", cur.PauseTotalNs, prev.PauseTotalNs, 1e-3, cloudwatch.StandardUnitMicroseconds)
+	m.addDelta(m.CollectNumGCPerInterval, "NumGCPerInterval", cur.NumGC, prev.NumGC, 1, cloudwatch.StandardUnitCount)
+	m.addDelta(m.CollectNumForcedGCPerInterval, "NumForcedGCPerInterval", cur.NumForcedGC, prev.NumForcedGC, 1, cloudwatch.StandardUnitCount)
+}
+
+// deltaStats holds the cumulative counters of the previous collection the
+// *PerInterval metrics are computed from.
+type deltaStats struct {
+	TotalAlloc   uint64
+	Lookups      uint64
+	Mallocs      uint64
+	Frees        uint64
+	PauseTotalNs uint64
+	NumGC        uint64
+	NumForcedGC  uint64
+	valid        bool
+}
+
+// addDelta adds the increase of the cumulative counter since the previous
+// collection multiplied by scale. A counter that decreased has been reset, e.g.
+// because the process the Source reads from restarted, the metric is skipped
+// for the interval then.
+func (m *GoMetrics) addDelta(enabled bool, name string, cur, prev uint64, scale float64, unit string) {
+	if cur < prev {
+		return
+	}
+
+	m.add(enabled, name, float64(cur-prev)*scale, unit)
+}
+
+func (m *GoMetrics) collectCPU() {
+	if !m.collects("CPUPercent", m.CollectCPUPercent) {
+		// forget the sample so that re-enabling doesn't average over the
+		// period the metric was off
+		m.state().cpu = cpuSample{}
+		return
+	}
+
+	used, ok := processCPUTime()
+	if !ok {
+		return
+	}
+
+	prev := m.state().cpu
+	m.state().cpu = cpuSample{used: used, at: time.Now()}
+
+	if prev.at.IsZero() {
+		return
+	}
+
+	elapsed := m.state().cpu.at.Sub(prev.at)
+	if elapsed <= 0 {
+		return
+	}
+
+	percent := 100 * float64(used-prev.used) / float64(elapsed) / float64(runtime.NumCPU())
+	m.add(true, "CPUPercent", percent, cloudwatch.StandardUnitPercent)
+}
+
+func (m *GoMetrics) collectOpenFDs() {
+	if !m.collects("OpenFDs", m.CollectOpenFDs) {
+		return
+	}
+
+	if n, ok := openFDs(); ok {
+		m.add(true, "OpenFDs", float64(n), cloudwatch.StandardUnitCount)
+	}
+}
+
+func (m *GoMetrics) collectProcessMemory() {
+	if !m.collects("ProcessRSS", m.CollectProcessRSS) && !m.collects("ProcessVSZ", m.CollectProcessVSZ) {
+		return
+	}
+
+	if rss, vsz, ok := processMemory(); ok {
+		m.add(m.CollectProcessRSS, "ProcessRSS", float64(rss), cloudwatch.StandardUnitBytes)
+		m.add(m.CollectProcessVSZ, "ProcessVSZ", float64(vsz), cloudwatch.StandardUnitBytes)
+	}
+}
+
+func (m *GoMetrics) collectCPUQuota() {
+	if !m.collects("CPUQuota", m.CollectCPUQuota) {
+		return
+	}
+
+	if cpus, ok := cpuQuota(cgroupRoot); ok {
+		m.add(true, "CPUQuota", cpus, cloudwatch.StandardUnitCount)
+	}
+}
+
 func (m *GoMetrics) add(enabled bool, name string, val float64, unit string) {
-	if !enabled {
+	if !m.collects(name, enabled) || !m.due(name) {
 		return
 	}
 
 	now := time.Now()
 
-	m.batch.Add(m.Namespace, &cloudwatch.MetricDatum{
-		Dimensions: m.Dimensions,
-		MetricName: aws.String(name),
+	datum := &cloudwatch.MetricDatum{
+		Dimensions: m.dims,
+		MetricName: aws.String(m.NamePrefix + name + m.NameSuffix),
 		Value:      aws.Float64(val),
 		Unit:       aws.String(unit),
 		Timestamp:  &now,
-	})
+	}
+
+	if m.StorageResolution != 0 {
+		datum.StorageResolution = aws.Int64(m.StorageResolution)
+	}
+
+	m.batch.Add(m.Namespace, datum)
 }
 
-func (m *GoMetrics) determineECSDimenstions() {
-	ecsMetaURI := os.Getenv("ECS_CONTAINER_METADATA_URI")
+// dimensions returns Dimensions merged with the ones returned by
+// DimensionProvider.
+func (m *GoMetrics) dimensions() []*cloudwatch.Dimension {
+	if m.DimensionProvider == nil {
+		return m.Dimensions
+	}
+
+	provided := m.DimensionProvider()
+	if len(provided) == 0 {
+		return m.Dimensions
+	}
+
+	overridden := make(map[string]bool, len(provided))
+	for _, d := range provided {
+		overridden[aws.StringValue(d.Name)] = true
+	}
+
+	dims := make([]*cloudwatch.Dimension, 0, len(m.Dimensions)+len(provided))
+
+	for _, d := range m.Dimensions {
+		if !overridden[aws.StringValue(d.Name)] {
+			dims = append(dims, d)
+		}
+	}
+
+	return append(dims, provided...)
+}
+
+// determineECSDimenstions appends the ContainerID, Cluster, TaskARN and
+// ServiceName dimensions read from the ECS container metadata endpoint (v4 if
+// the agent provides it, v3 otherwise). It does nothing outside of ECS.
+func (m *GoMetrics) determineECSDimenstions(timeout time.Duration) error {
+	ecsMetaURI := ecsMetadataURI()
 	if ecsMetaURI == "" {
-		return
+		return nil
 	}
 
 	hclient := http.Client{
-		Timeout: 2 * time.Second,
+		Timeout: timeout,
 	}
 
-	r, err := hclient.Get(ecsMetaURI)
-	if err != nil {
-		return
+	container := struct{ DockerID string }{}
+	if err := getJSON(&hclient, ecsMetaURI, &container); err != nil {
+		return err
 	}
 
-	defer r.Body.Close()
+	m.appendDimension("ContainerID", container.DockerID)
 
-	if r.StatusCode != http.StatusOK {
-		return
+	task := struct {
+		Cluster     string
+		TaskARN     string
+		ServiceName string
+	}{}
+	if err := getJSON(&hclient, ecsMetaURI+"/task", &task); err != nil {
+		return err
 	}
 
-	payload := struct{ DockerID string }{}
+	m.appendDimension("Cluster", task.Cluster)
+	m.appendDimension("TaskARN", task.TaskARN)
+	m.appendDimension("ServiceName", task.ServiceName)
 
-	err = json.NewDecoder(r.Body).Decode(&payload)
-	if err != nil {
+	return nil
+}
+
+// ecsMetadataURI returns the address of the ECS container metadata endpoint,
+// preferring the version 4 one. It's empty outside of ECS.
+func ecsMetadataURI() string {
+	if uri := os.Getenv("ECS_CONTAINER_METADATA_URI_V4"); uri != "" {
+		return uri
+	}
+
+	return os.Getenv("ECS_CONTAINER_METADATA_URI")
+}
+
+func (m *GoMetrics) appendDimension(name, value string) {
+	if value == "" {
 		return
 	}
 
 	m.Dimensions = append(m.Dimensions, &cloudwatch.Dimension{
-		Name:  aws.String("ContainerID"),
-		Value: aws.String(payload.DockerID),
+		Name:  aws.String(name),
+		Value: aws.String(value),
 	})
 }
 
-func (m *GoMetrics) determineEC2Dimenstions(sess client.ConfigProvider) {
-	client := ec2metadata.New(sess)
-	if !client.Available() {
-		return
+func getJSON(hclient *http.Client, url string, v interface{}) error {
+	r, err := hclient.Get(url)
+	if err != nil {
+		return fmt.Errorf("gometrics: reading ECS metadata: %w", err)
 	}
 
-	metadata, err := client.GetInstanceIdentityDocument()
-	if err != nil {
-		return
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("gometrics: reading ECS metadata from %s: unexpected status %s", url, r.Status)
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("gometrics: decoding ECS metadata from %s: %w", url, err)
 	}
 
-	m.Dimensions = append(m.Dimensions,
-		&cloudwatch.Dimension{Name: aws.String("InstanceID"), Value: aws.String(metadata.InstanceID)},
-		&cloudwatch.Dimension{Name: aws.String("AZ"), Value: aws.String(metadata.AvailabilityZone)},
-	)
+	return nil
 }
@@ -0,0 +1,466 @@
+package gometrics
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cwMock struct {
+	cloudwatchiface.CloudWatchAPI
+	sync.Mutex
+	names       []string
+	resolutions []int64
+	values      []float64
+	dims        [][]*cloudwatch.Dimension
+}
+
+func (mock *cwMock) PutMetricDataWithContext(
+	_ aws.Context, input *cloudwatch.PutMetricDataInput, _ ...request.Option,
+) (*cloudwatch.PutMetricDataOutput, error) {
+	mock.Lock()
+	defer mock.Unlock()
+
+	for _, d := range input.MetricData {
+		mock.names = append(mock.names, aws.StringValue(d.MetricName))
+		mock.resolutions = append(mock.resolutions, aws.Int64Value(d.StorageResolution))
+		mock.values = append(mock.values, aws.Float64Value(d.Value))
+		mock.dims = append(mock.dims, d.Dimensions)
+	}
+
+	return nil, nil
+}
+
+func newTestGoMetrics(cwAPI cloudwatchiface.CloudWatchAPI) *GoMetrics {
+	return &GoMetrics{Namespace: "test", batch: cwatsch.New(cwAPI)}
+}
+
+func TestSetCollect(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectHeapAlloc = true
+	m.SetCollect("HeapAlloc", false)
+	m.SetCollect("NumGoroutine", true)
+
+	var stats Stats
+
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"NumGoroutine"}, cwAPI.names)
+}
+
+func TestIntervals(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectNumGoroutine = true
+	m.CollectNumGC = true
+	m.Intervals = map[string]time.Duration{"NumGC": time.Hour, "NumGoroutine": 0}
+
+	var stats Stats
+
+	m.collect(&stats)
+	m.collectOn(&intervalState{interval: time.Hour}, &stats)
+	m.collectOn(&intervalState{interval: time.Minute}, &stats)
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"NumGoroutine", "NumGC"}, cwAPI.names)
+}
+
+func TestLaunchRunsIntervals(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectNumGoroutine = true
+	m.CollectNumGC = true
+	m.Intervals = map[string]time.Duration{"NumGC": time.Millisecond, "NumGoroutine": time.Hour}
+
+	assert.Equal(t, []time.Duration{time.Millisecond, time.Second}, (&GoMetrics{
+		Intervals: map[string]time.Duration{"a": time.Second, "b": time.Millisecond, "c": time.Second, "d": 0, "e": time.Hour},
+		interval:  time.Hour,
+	}).launchIntervals())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		m.Launch(ctx, time.Hour)
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+
+	require.NoError(t, m.batch.Flush())
+
+	assert.NotEmpty(t, cwAPI.names)
+
+	for _, name := range cwAPI.names {
+		assert.Equal(t, "NumGC", name)
+	}
+}
+
+func TestNamePrefixAndSuffix(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectNumGoroutine = true
+	m.CollectHeapAlloc = true
+	m.SetCollect("HeapAlloc", false)
+
+	var stats Stats
+
+	m.collect(&stats)
+
+	m.NamePrefix = "api_"
+	m.collect(&stats)
+
+	m.NameSuffix = "_v2"
+	m.collect(&stats)
+
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"NumGoroutine", "api_NumGoroutine", "api_NumGoroutine_v2"}, cwAPI.names)
+}
+
+type fakeSource struct {
+	stats Stats
+	err   error
+}
+
+func (s *fakeSource) Read(stats *Stats) error {
+	*stats = s.stats
+	return s.err
+}
+
+func TestSource(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectHeapAlloc = true
+	m.CollectNumGoroutine = true
+
+	source := &fakeSource{}
+	source.stats.HeapAlloc = 1024
+	source.stats.NumGoroutine = 7
+	m.Source = source
+
+	var errs []error
+
+	m.OnError = func(err error) { errs = append(errs, err) }
+
+	var stats Stats
+
+	m.collect(&stats)
+
+	source.err = errors.New("unreachable")
+	m.collect(&stats)
+
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"HeapAlloc", "NumGoroutine"}, cwAPI.names)
+	assert.Equal(t, []float64{1024, 7}, cwAPI.values)
+	assert.Equal(t, []error{source.err}, errs)
+}
+
+func TestSetCollectWhileRunning(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		m.Launch(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	for i := 0; i < 50; i++ {
+		m.SetCollect("NumGoroutine", i%2 == 0)
+		m.SetCollect("HeapAlloc", i%3 == 0)
+		time.Sleep(100 * time.Microsecond)
+	}
+
+	cancel()
+	<-done
+
+	require.NoError(t, m.batch.Flush())
+
+	for _, name := range cwAPI.names {
+		assert.Contains(t, []string{"NumGoroutine", "HeapAlloc"}, name)
+	}
+}
+
+func TestStorageResolution(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectNumGoroutine = true
+
+	var stats Stats
+
+	m.collect(&stats)
+
+	m.StorageResolution = 1
+	m.collect(&stats)
+
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []int64{0, 1}, cwAPI.resolutions)
+}
+
+func TestCPUPercent(t *testing.T) {
+	if _, ok := processCPUTime(); !ok {
+		t.Skip("CPU time isn't available on this platform")
+	}
+
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectCPUPercent = true
+
+	var stats Stats
+
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+	assert.Empty(t, cwAPI.names, "first collection only takes the sample")
+
+	for start := time.Now(); time.Since(start) < 20*time.Millisecond; {
+		runtime.ReadMemStats(&stats.MemStats)
+	}
+
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+	assert.Equal(t, []string{"CPUPercent"}, cwAPI.names)
+}
+
+func TestOpenFDs(t *testing.T) {
+	before, ok := openFDs()
+	if !ok {
+		t.Skip("the open file descriptors aren't available on this platform")
+	}
+
+	f, err := os.Open(os.Args[0])
+	require.NoError(t, err)
+
+	defer f.Close()
+
+	after, _ := openFDs()
+	assert.Equal(t, before+1, after)
+
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectOpenFDs = true
+
+	var stats Stats
+
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+	assert.Equal(t, []string{"OpenFDs"}, cwAPI.names)
+}
+
+func TestProcessMemory(t *testing.T) {
+	rss, vsz, ok := processMemory()
+	if !ok {
+		t.Skip("the memory sizes of the process aren't available on this platform")
+	}
+
+	assert.Greater(t, rss, uint64(0))
+	assert.GreaterOrEqual(t, vsz, rss)
+
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectProcessRSS = true
+
+	var stats Stats
+
+	m.collect(&stats)
+	m.CollectProcessVSZ = true
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+
+	assert.ElementsMatch(t, []string{"ProcessRSS", "ProcessRSS", "ProcessVSZ"}, cwAPI.names)
+}
+
+func TestGCPausePercentiles(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectGCPausePercentiles = true
+	m.lastNumGC = 300
+
+	var stats runtime.MemStats
+
+	// the oldest entries must be ignored as they happened before the previous
+	// collection
+	stats.PauseNs[(300+255)%256] = 1e9
+
+	for i := uint32(1); i <= 10; i++ {
+		stats.PauseNs[(300+i+255)%256] = uint64(i) * 1000
+	}
+
+	stats.NumGC = 310
+	m.collectGCPauses(&stats)
+
+	// no GC since the previous collection
+	m.collectGCPauses(&stats)
+
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"GCPauseP50", "GCPauseP90", "GCPauseP99"}, cwAPI.names)
+	assert.Equal(t, []float64{5, 9, 10}, cwAPI.values)
+}
+
+func TestGCPausePercentilesSkipResets(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectGCPausePercentiles = true
+	m.lastNumGC = 300
+
+	var stats runtime.MemStats
+
+	for i := range stats.PauseNs {
+		stats.PauseNs[i] = 1e9
+	}
+
+	stats.NumGC = 2
+	m.collectGCPauses(&stats)
+
+	require.NoError(t, m.batch.Flush())
+	assert.Empty(t, cwAPI.names, "the interval of the reset is skipped")
+	assert.Equal(t, uint32(2), m.lastNumGC)
+
+	stats.PauseNs[2] = 3000
+	stats.NumGC = 3
+	m.collectGCPauses(&stats)
+
+	require.NoError(t, m.batch.Flush())
+	assert.Equal(t, []float64{3, 3, 3}, cwAPI.values)
+}
+
+func TestLaunchFlushesOnCancel(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectNumGoroutine = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	m.Launch(ctx, time.Millisecond)
+
+	assert.NotEmpty(t, cwAPI.names)
+	assert.Zero(t, m.batch.Len())
+}
+
+func TestDimensionProvider(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectNumGoroutine = true
+	m.Dimensions = []*cloudwatch.Dimension{
+		{Name: aws.String("InstanceID"), Value: aws.String("i-1")},
+		{Name: aws.String("AZ"), Value: aws.String("eu-west-1a")},
+	}
+
+	pod := "pod-1"
+	m.DimensionProvider = func() []*cloudwatch.Dimension {
+		return []*cloudwatch.Dimension{
+			{Name: aws.String("AZ"), Value: aws.String("eu-west-1b")},
+			{Name: aws.String("Pod"), Value: aws.String(pod)},
+		}
+	}
+
+	var stats Stats
+
+	m.collect(&stats)
+
+	pod = "pod-2"
+	m.collect(&stats)
+
+	require.NoError(t, m.batch.Flush())
+
+	require.Len(t, cwAPI.dims, 2)
+	assert.Equal(t, []*cloudwatch.Dimension{
+		{Name: aws.String("InstanceID"), Value: aws.String("i-1")},
+		{Name: aws.String("AZ"), Value: aws.String("eu-west-1b")},
+		{Name: aws.String("Pod"), Value: aws.String("pod-1")},
+	}, cwAPI.dims[0])
+	assert.Equal(t, "pod-2", aws.StringValue(cwAPI.dims[1][2].Value))
+}
+
+func TestCollectAll(t *testing.T) {
+	m := &GoMetrics{}
+	m.CollectAll()
+
+	v := reflect.ValueOf(m).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Name; strings.HasPrefix(name, "Collect") {
+			assert.True(t, v.Field(i).Bool(), name)
+		}
+	}
+
+	m.CollectNone()
+
+	for i := 0; i < v.NumField(); i++ {
+		if name := v.Type().Field(i).Name; strings.HasPrefix(name, "Collect") {
+			assert.False(t, v.Field(i).Bool(), name)
+		}
+	}
+}
+
+func TestEnable(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.Enable("HeapAlloc", "NumGoroutine")
+
+	var stats Stats
+
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"HeapAlloc", "NumGoroutine"}, cwAPI.names)
+}
+
+func TestPerIntervalDeltas(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectMallocsPerInterval = true
+	m.CollectTotalAllocPerInterval = true
+
+	m.collectDeltas(&runtime.MemStats{Mallocs: 100, TotalAlloc: 1000})
+	require.NoError(t, m.batch.Flush())
+	assert.Empty(t, cwAPI.names, "first collection only takes the sample")
+
+	m.collectDeltas(&runtime.MemStats{Mallocs: 150, TotalAlloc: 4000})
+	m.collectDeltas(&runtime.MemStats{Mallocs: 150, TotalAlloc: 4096})
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{
+		"TotalAllocPerInterval", "MallocsPerInterval",
+		"TotalAllocPerInterval", "MallocsPerInterval",
+	}, cwAPI.names)
+	assert.Equal(t, []float64{3000, 50, 96, 0}, cwAPI.values)
+}
+
+func TestNumGCPerIntervalSkipsResets(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.CollectNumGCPerInterval = true
+
+	m.collectDeltas(&runtime.MemStats{NumGC: 10})
+	m.collectDeltas(&runtime.MemStats{NumGC: 13})
+	m.collectDeltas(&runtime.MemStats{NumGC: 2})
+	m.collectDeltas(&runtime.MemStats{NumGC: 7})
+	require.NoError(t, m.batch.Flush())
+
+	assert.Equal(t, []string{"NumGCPerInterval", "NumGCPerInterval"}, cwAPI.names)
+	assert.Equal(t, []float64{3, 5}, cwAPI.values, "the interval of the reset is skipped")
+}
@@ -0,0 +1,25 @@
+package gometrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// processMemory returns the resident set size and the virtual memory size of
+// the process in bytes.
+func processMemory() (rss, vsz uint64, ok bool) {
+	statm, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, 0, false
+	}
+
+	var size, resident uint64
+	if _, err := fmt.Sscan(string(statm), &size, &resident); err != nil {
+		return 0, 0, false
+	}
+
+	pageSize := uint64(os.Getpagesize())
+
+	return resident * pageSize, size * pageSize, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package gometrics
+
+// processMemory reports that the memory sizes of the process aren't available
+// on this platform.
+func processMemory() (rss, vsz uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,124 @@
+package gometrics
+
+import (
+	"math"
+	"runtime/metrics"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// runtimeHistograms maps the runtime/metrics histograms collected when
+// GoMetrics.UseRuntimeMetrics is set to the names of the emitted metrics.
+var runtimeHistograms = []struct {
+	sample string
+	name   string
+}{
+	{"/gc/pauses:seconds", "GCPauses"},
+	{"/sched/latencies:seconds", "SchedLatencies"},
+}
+
+var runtimePercentiles = []struct {
+	suffix string
+	p      float64
+}{
+	{"P50", 0.5},
+	{"P90", 0.9},
+	{"P99", 0.99},
+}
+
+type runtimeState struct {
+	samples []metrics.Sample
+	counts  map[string][]uint64
+}
+
+// collectRuntimeMetrics emits the 50th, 90th and 99th percentiles of the
+// runtime/metrics histograms (e.g. GCPausesP99) in microseconds. Only the
+// observations made since the previous collection are taken into account.
+// Histograms the running Go version doesn't support are skipped.
+func (m *GoMetrics) collectRuntimeMetrics() {
+	if !m.UseRuntimeMetrics {
+		return
+	}
+
+	state := &m.state().rtMetrics
+	if state.samples == nil {
+		state.samples = make([]metrics.Sample, len(runtimeHistograms))
+		for i, h := range runtimeHistograms {
+			state.samples[i].Name = h.sample
+		}
+
+		state.counts = map[string][]uint64{}
+	}
+
+	metrics.Read(state.samples)
+
+	for i, h := range runtimeHistograms {
+		sample := state.samples[i]
+		if sample.Value.Kind() != metrics.KindFloat64Histogram {
+			continue
+		}
+
+		hist := sample.Value.Float64Histogram()
+		prev := state.counts[h.sample]
+		delta := make([]uint64, len(hist.Counts))
+
+		for j, c := range hist.Counts {
+			delta[j] = c
+			if j < len(prev) && prev[j] <= c {
+				delta[j] -= prev[j]
+			}
+		}
+
+		state.counts[h.sample] = append(prev[:0], hist.Counts...)
+
+		for _, p := range runtimePercentiles {
+			v, ok := histogramPercentile(delta, hist.Buckets, p.p)
+			if !ok {
+				continue
+			}
+
+			m.add(true, h.name+p.suffix, v*1e6, cloudwatch.StandardUnitMicroseconds)
+		}
+	}
+}
+
+// histogramPercentile returns the upper boundary of the bucket containing the
+// p-th percentile of the observations. buckets holds the boundaries of the
+// buckets, so it's one element longer than counts. If the percentile falls
+// into the unbounded top bucket, its lower boundary is returned instead.
+func histogramPercentile(counts []uint64, buckets []float64, p float64) (float64, bool) {
+	var total uint64
+	for _, c := range counts {
+		total += c
+	}
+
+	if total == 0 {
+		return 0, false
+	}
+
+	rank := uint64(math.Ceil(p * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+
+	var seen uint64
+
+	for i, c := range counts {
+		seen += c
+		if seen < rank {
+			continue
+		}
+
+		if upper := buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper, true
+		}
+
+		if lower := buckets[i]; !math.IsInf(lower, -1) {
+			return lower, true
+		}
+
+		return 0, false
+	}
+
+	return 0, false
+}
@@ -0,0 +1,51 @@
+package gometrics
+
+import (
+	"math"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 1, 2, 3, math.Inf(1)}
+
+	tests := []struct {
+		counts []uint64
+		p      float64
+		want   float64
+		ok     bool
+	}{
+		{[]uint64{0, 10, 0, 0}, 0.5, 2, true},
+		{[]uint64{0, 5, 4, 1}, 0.5, 2, true},
+		{[]uint64{0, 5, 4, 1}, 0.9, 3, true},
+		{[]uint64{0, 5, 4, 1}, 0.99, 3, true},
+		{[]uint64{1, 0, 0, 0}, 0.5, 1, true},
+		{[]uint64{0, 0, 0, 0}, 0.5, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := histogramPercentile(tt.counts, buckets, tt.p)
+		assert.Equal(t, tt.ok, ok, "counts %v p %v", tt.counts, tt.p)
+		assert.Equal(t, tt.want, got, "counts %v p %v", tt.counts, tt.p)
+	}
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	cwAPI := cwMock{}
+	m := newTestGoMetrics(&cwAPI)
+	m.UseRuntimeMetrics = true
+	m.SetCollect("SchedLatenciesP50", false)
+
+	var stats Stats
+
+	runtime.GC()
+	m.collect(&stats)
+	require.NoError(t, m.batch.Flush())
+
+	assert.Subset(t, cwAPI.names, []string{"GCPausesP50", "GCPausesP90", "GCPausesP99"})
+	assert.NotContains(t, cwAPI.names, "SchedLatenciesP50")
+
+}
@@ -0,0 +1,35 @@
+package gometrics
+
+import "runtime"
+
+// Stats are the statistics of a Go process the metrics are computed from.
+type Stats struct {
+	runtime.MemStats
+	NumGoroutine int
+}
+
+// StatsSource provides the statistics the metrics are computed from, see
+// GoMetrics.Source.
+type StatsSource interface {
+	// Read fills stats with the current statistics.
+	Read(stats *Stats) error
+}
+
+// RuntimeSource reads the statistics of the running process from the runtime.
+// It's the default source.
+type RuntimeSource struct{}
+
+func (RuntimeSource) Read(stats *Stats) error {
+	runtime.ReadMemStats(&stats.MemStats)
+	stats.NumGoroutine = runtime.NumGoroutine()
+
+	return nil
+}
+
+func (m *GoMetrics) source() StatsSource {
+	if m.Source == nil {
+		return RuntimeSource{}
+	}
+
+	return m.Source
+}
@@ -0,0 +1,94 @@
+package cwatsch
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// AddHistorical sends historical data, e.g. during a bulk import. Unlike Add,
+// the data doesn't go to the buffer: it's grouped by the minute of the datums'
+// timestamps and sent right away, minute by minute starting from the oldest
+// one, so that each request carries temporally coherent data. Datums without a
+// timestamp are assigned the current time.
+//
+// Datums older than CloudWatch accepts (two weeks) are dropped and reported as
+// *DatumError via WithOnError, unless WithTimestampClamping is used. Sending
+// stops at the first minute that fails, the error is returned and the data
+// that hasn't been sent is dropped.
+func (b *Batch) AddHistorical(namespace string, data ...*cw.MetricDatum) error {
+	return b.AddHistoricalCtx(context.Background(), namespace, data...)
+}
+
+func (b *Batch) AddHistoricalCtx(ctx context.Context, namespace string, data ...*cw.MetricDatum) error {
+	now := time.Now()
+	buckets := map[int64][]*cw.MetricDatum{}
+
+	var errs []error
+
+	b.Lock()
+
+	for _, datum := range data {
+		datum, err := b.prepare(namespace, datum)
+		if err == nil && datum.Timestamp == nil {
+			d := *datum
+			d.Timestamp = aws.Time(now)
+			datum = &d
+		}
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		minute := datum.Timestamp.Truncate(time.Minute).Unix()
+		buckets[minute] = append(buckets[minute], datum)
+	}
+
+	b.Unlock()
+
+	for _, err := range errs {
+		atomic.AddInt64(&b.counters.dropped, 1)
+		b.reportError(err)
+	}
+
+	minutes := make([]int64, 0, len(buckets))
+	for minute := range buckets {
+		minutes = append(minutes, minute)
+	}
+
+	sort.Slice(minutes, func(i, j int) bool { return minutes[i] < minutes[j] })
+
+	for i, minute := range minutes {
+		flush, flushCtx := b.newFlush(ctx, b.send)
+		flush.requeue, flush.failed = nil, nil
+
+		// the queue splits the bucket into requests within the limits of
+		// CloudWatch, the same way the buffered metrics are
+		q := b.newQueue()
+		q.grouped = false
+
+		for _, datum := range buckets[minute] {
+			q.push(datum)
+		}
+
+		for q.count > 0 {
+			flush.do(flushCtx, namespace, q.top(b.batchSize))
+		}
+
+		if err := flush.wait(); err != nil {
+			// the remaining minutes aren't even attempted
+			for _, minute := range minutes[i+1:] {
+				atomic.AddInt64(&b.counters.dropped, int64(len(buckets[minute])))
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
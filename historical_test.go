@@ -0,0 +1,108 @@
+package cwatsch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddHistorical(t *testing.T) {
+	cwAPI := cwMock{}
+
+	var errs []error
+
+	batch := cwatsch.New(&cwAPI, cwatsch.WithOnError(func(err error) { errs = append(errs, err) }))
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	data := []*cw.MetricDatum{}
+
+	// 25 datums in the second minute, 3 in the first one, interleaved
+	for i := 0; i < 25; i++ {
+		data = append(data, cwatsch.Datum("calls").Value(1).At(start.Add(time.Minute+time.Duration(i)*time.Second)).Build())
+		if i < 3 {
+			data = append(data, cwatsch.Datum("calls").Value(1).At(start.Add(time.Duration(i)*time.Second)).Build())
+		}
+	}
+
+	tooOld := cwatsch.Datum("calls").Value(1).At(time.Now().Add(-15 * 24 * time.Hour)).Build()
+	data = append(data, tooOld)
+
+	require.NoError(t, batch.AddHistorical("myApp", data...))
+
+	payloads := cwAPI.payloads()
+	require.Len(t, payloads, 3)
+	assert.Equal(t, 3, len(payloads[0].MetricData), "oldest minute goes first")
+
+	for _, d := range payloads[0].MetricData {
+		assert.Equal(t, start, d.Timestamp.Truncate(time.Minute))
+	}
+
+	assert.ElementsMatch(t, []int{20, 5}, payloadSizes(payloads[1:]))
+
+	for _, p := range payloads[1:] {
+		for _, d := range p.MetricData {
+			assert.Equal(t, start.Add(time.Minute), d.Timestamp.Truncate(time.Minute))
+		}
+	}
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, tooOld, errs[0].(*cwatsch.DatumError).Datum)
+	assert.Equal(t, int64(0), batch.Stats().Pending, "historical data bypasses the buffer")
+	assert.Equal(t, int64(1), batch.Stats().Dropped)
+}
+
+func TestAddHistoricalStopsOnFailure(t *testing.T) {
+	cwAPI := cwMock{err: assert.AnError}
+	batch := cwatsch.New(&cwAPI)
+
+	start := time.Now().Add(-time.Hour)
+
+	err := batch.AddHistorical("myApp",
+		cwatsch.Datum("calls").Value(1).At(start).Build(),
+		cwatsch.Datum("calls").Value(1).At(start.Add(time.Minute)).Build(),
+		&cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)},
+	)
+
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, int64(1), batch.Stats().APICalls)
+	assert.Equal(t, int64(3), batch.Stats().Dropped)
+}
+
+func TestAddHistoricalRespectsRequestLimits(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithSizeEstimator(func(d *cw.MetricDatum) int {
+		if aws.StringValue(d.MetricName) == "large" {
+			return 300 * 1024
+		}
+
+		return 100
+	}))
+
+	ts := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	values := make([]float64, 150)
+
+	data := []*cw.MetricDatum{}
+	for i := 0; i < 14; i++ {
+		data = append(data, &cw.MetricDatum{
+			MetricName: aws.String("values"),
+			Values:     aws.Float64Slice(values),
+			Timestamp:  aws.Time(ts),
+		})
+	}
+
+	for i := 0; i < 5; i++ {
+		data = append(data, cwatsch.Datum("large").Value(1).At(ts.Add(time.Minute)).Build())
+	}
+
+	require.NoError(t, batch.AddHistorical("myApp", data...))
+
+	payloads := cwAPI.payloads()
+	require.Len(t, payloads, 5)
+	assert.ElementsMatch(t, []int{6, 6, 2}, payloadSizes(payloads[:3]), "at most 1000 values per request")
+	assert.ElementsMatch(t, []int{3, 2}, payloadSizes(payloads[3:]), "at most 1MB per request")
+}
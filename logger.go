@@ -0,0 +1,30 @@
+package cwatsch
+
+// Logger receives the log messages of the batch, see WithLogger. The details
+// come as alternating keys and values, e.g. "namespace", "myApp", "size", 20.
+// A Logger must be safe for concurrent use.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// WithLogger makes the batch log what it's doing to l: every request sent
+// (namespace, number of datums and duration) and every finished flush at the
+// debug level, the retries at the info level and the failed requests at the
+// error level. Nothing is logged by default.
+func WithLogger(l Logger) Option {
+	return func(b *Batch) {
+		if l == nil {
+			l = nopLogger{}
+		}
+
+		b.logger = l
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
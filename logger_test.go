@@ -0,0 +1,93 @@
+package cwatsch_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) log(level, msg string, keysAndValues []interface{}) {
+	l.Lock()
+	defer l.Unlock()
+
+	fields := map[string]interface{}{}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *recordingLogger) Debug(msg string, kv ...interface{}) { l.log("debug", msg, kv) }
+func (l *recordingLogger) Info(msg string, kv ...interface{})  { l.log("info", msg, kv) }
+func (l *recordingLogger) Error(msg string, kv ...interface{}) { l.log("error", msg, kv) }
+
+func (l *recordingLogger) find(msg string) []logEntry {
+	l.Lock()
+	defer l.Unlock()
+
+	var found []logEntry
+
+	for _, e := range l.entries {
+		if e.msg == msg {
+			found = append(found, e)
+		}
+	}
+
+	return found
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithLogger(logger))
+
+	batch.Add("myApp", metricData("metric", 25)...)
+	require.NoError(t, batch.Flush())
+
+	sent := logger.find("cwatsch: request sent")
+	require.Len(t, sent, 2)
+
+	sizes := []interface{}{sent[0].fields["size"], sent[1].fields["size"]}
+	assert.ElementsMatch(t, []interface{}{20, 5}, sizes)
+	assert.Equal(t, "myApp", sent[0].fields["namespace"])
+	assert.Contains(t, sent[0].fields, "duration")
+
+	finished := logger.find("cwatsch: flush finished")
+	require.Len(t, finished, 1)
+	assert.Equal(t, "debug", finished[0].level)
+	assert.Equal(t, int64(25), finished[0].fields["sent"])
+
+	cwAPI.err = errors.New("boom")
+
+	batch.Add("myApp", metricData("metric", 1)...)
+	require.Error(t, batch.Flush())
+
+	failed := logger.find("cwatsch: request failed")
+	require.Len(t, failed, 1)
+	assert.Equal(t, "error", failed[0].level)
+	assert.Equal(t, cwAPI.err, failed[0].fields["error"])
+}
+
+func TestNilLogger(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithLogger(nil))
+
+	batch.Add("myApp", metricData("metric", 1)...)
+	require.NoError(t, batch.Flush())
+}
@@ -10,10 +10,14 @@ package cwatsch
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 	"golang.org/x/sync/errgroup"
@@ -22,116 +26,686 @@ import (
 const maxBatchSize = 20
 
 type Batch struct {
+	counters counters // accessed atomically, kept first for alignment
+
 	sync.Mutex
-	cwAPI    cloudwatchiface.CloudWatchAPI
-	metricQs map[string]*queue
+	cwAPI     cloudwatchiface.CloudWatchAPI
+	routes    sync.Map
+	metricQs  map[string]*queue
+	batchSize int
+	flushSem  chan struct{}
+	logger    Logger
+	compress  int32
+
+	orderedFlush  bool
+	flushPriority []string
+
+	defaultDims     []*cw.Dimension
+	namePrefixes    map[string]string
+	timestampJitter time.Duration
+	timestampBucket time.Duration
+	sampleRate      float64
+	sizeEstimator   func(*cw.MetricDatum) int
+	propagateCtx    bool
+
+	emitOnChange map[string]time.Duration
+	lastValues   map[string]map[string]lastValue
+
+	validation           bool
+	clampTimestamps      bool
+	percentiles          map[string]bool
+	conflictPolicy       ConflictPolicy
+	dimensionLimitPolicy DimensionLimitPolicy
+	clamps               map[string]valueRange
+
+	aggregation   bool
+	compaction    bool
+	valueArrays   bool
+	rawNamespaces map[string]bool
+
+	gauges            map[string]gauge
+	timings           map[string]*timing
+	timingPercentiles []float64
+	counterSet        sync.Map
+
+	cancelledFlushTimeout time.Duration
+	preserveOnCancelled   bool
+
+	liveness       *livenessMetric
+	flushJitter    float64
+	failureBackoff time.Duration
+
+	retryAttempts int
+	retryDelay    time.Duration
+
+	maxQueueSize int
+	dropPolicy   DropPolicy
+	space        *sync.Cond
+
+	requeueAttempts int
+	attemptsMu      sync.Mutex
+	attempts        map[*cw.MetricDatum]int
+
+	nsStatsMu sync.Mutex
+	nsStats   map[string]NamespaceStat
+
+	onError           func(error)
+	onFlushLatency    func(d time.Duration, batchSize int)
+	deadLetter        func(ns string, data []*cw.MetricDatum)
+	deadLetterMu      sync.Mutex
+	onFlushError      func(namespace string, batch []*cw.MetricDatum, err error)
+	onFlushErrorMu    sync.Mutex
+	globalThreshold   int
+	thresholdFlushing int32
+	shutdownOnce      sync.Once
+	diskBufferDir     string
+	disk              *diskBuffer
+	preserveInputs    bool
+
+	internalNS    *string
+	modMu         sync.Mutex
+	modifications map[string]int64
 }
 
-func New(cwAPI cloudwatchiface.CloudWatchAPI) *Batch {
+// Option configures Batch. Options are passed to New.
+type Option func(*Batch)
+
+func New(cwAPI cloudwatchiface.CloudWatchAPI, opts ...Option) *Batch {
 	b := &Batch{
-		cwAPI:    cwAPI,
-		metricQs: map[string]*queue{},
+		cwAPI:       cwAPI,
+		metricQs:    map[string]*queue{},
+		flushJitter: defaultFlushJitter,
+		batchSize:   maxBatchSize,
+		logger:      nopLogger{},
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if b.diskBufferDir != "" {
+		b.openDiskBuffer()
 	}
 
 	return b
 }
 
+// WithMaxBatchSize limits the number of datums sent in one request to n, which
+// is clamped to the range between 1 and 20 (the max CloudWatch accepts). Small
+// batches are mostly useful in tests. Complete batches (see
+// FlushCompleteBatches) are of this size as well.
+func WithMaxBatchSize(n int) Option {
+	return func(b *Batch) {
+		switch {
+		case n < 1:
+			n = 1
+		case n > maxBatchSize:
+			n = maxBatchSize
+		}
+
+		b.batchSize = n
+	}
+}
+
+// WithMaxConcurrentFlushes limits the number of PutMetricData requests the
+// batch has in flight at once to n, so that flushing a large backlog doesn't
+// get throttled. The limit is shared by all the flushes of the batch and
+// covers the retries as well. n <= 0 means no limit, which is the default.
+func WithMaxConcurrentFlushes(n int) Option {
+	return func(b *Batch) {
+		if n <= 0 {
+			b.flushSem = nil
+			return
+		}
+
+		b.flushSem = make(chan struct{}, n)
+	}
+}
+
+// WithOnError registers a function receiving the errors that happen in the
+// background, i.e. outside of a call that could return them.
+func WithOnError(onError func(error)) Option {
+	return func(b *Batch) {
+		b.onError = onError
+	}
+}
+
+// WithOnFlushError registers a function receiving every failed request of a
+// flush: its namespace, its datums and the error. Unlike the error returned by
+// Flush, this tells exactly which metrics have been affected, e.g. to log them
+// or to persist them elsewhere. The calls are serialized, the function
+// doesn't need to be safe for concurrent use, but it should return quickly as
+// it holds up the flush. batch must not be modified.
+func WithOnFlushError(fn func(namespace string, batch []*cw.MetricDatum, err error)) Option {
+	return func(b *Batch) {
+		b.onFlushError = fn
+	}
+}
+
+// WithOnFlushLatency registers a function receiving the duration of every
+// PutMetricData call and the number of datums it carried. Only the call itself
+// is measured, e.g. the time spent waiting for a free slot or between retries
+// isn't included. The function is called concurrently from the goroutines
+// sending the requests, so it must be safe for concurrent use.
+func WithOnFlushLatency(fn func(d time.Duration, batchSize int)) Option {
+	return func(b *Batch) {
+		b.onFlushLatency = fn
+	}
+}
+
+func (b *Batch) reportFlushError(ns string, batch []*cw.MetricDatum, err error) {
+	if b.onFlushError == nil {
+		return
+	}
+
+	b.onFlushErrorMu.Lock()
+	defer b.onFlushErrorMu.Unlock()
+
+	b.onFlushError(ns, batch, err)
+}
+
+// WithGlobalFlushThreshold makes the batch flush all the collected metrics as
+// soon as the total number of buffered metrics across all the namespaces
+// exceeds n. This keeps the buffer small even when each of many namespaces
+// holds just a handful of metrics. The flush runs in the background, its
+// errors are passed to the function registered with WithOnError.
+func WithGlobalFlushThreshold(n int) Option {
+	return func(b *Batch) {
+		b.globalThreshold = n
+	}
+}
+
+// WithPreserveInputBatches makes the batch respect the grouping of the metrics
+// passed in one input (or one call to Add): the metrics of an input are sent in
+// the same request, provided there are at most 20 of them. Inputs are still
+// packed together into one request as long as they fit. By default the metrics
+// of a namespace are merged and split into requests regardless of how they
+// were added.
+func WithPreserveInputBatches() Option {
+	return func(b *Batch) {
+		b.preserveInputs = true
+	}
+}
+
+func (b *Batch) reportError(err error) {
+	if err != nil && b.onError != nil {
+		b.onError(err)
+	}
+}
+
 func (b *Batch) PutMetricData(input *cw.PutMetricDataInput) (*cw.PutMetricDataOutput, error) {
 	b.AddInputs(input)
 	return &cw.PutMetricDataOutput{}, nil
 }
 
+// PutMetricDataWithContext queues the input the same way PutMetricData does,
+// so the batch can stand in for the CloudWatch client in code using the
+// context variant. ctx is used when waiting for space in a full queue (see
+// AddCtx) and, with WithContextPropagation, for the values of the requests
+// eventually sending the input. The request options are ignored.
+func (b *Batch) PutMetricDataWithContext(
+	ctx aws.Context, input *cw.PutMetricDataInput, _ ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	err := b.add(ctx, input)
+	b.checkGlobalThreshold()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &cw.PutMetricDataOutput{}, nil
+}
+
 func (b *Batch) Add(namespace string, data ...*cw.MetricDatum) *Batch {
-	b.add(&cw.PutMetricDataInput{
-		Namespace:  aws.String(namespace),
-		MetricData: data,
-	})
+	_ = b.AddCtx(context.Background(), namespace, data...)
+
+	return b
+}
+
+// AddHighRes adds the datums as high-resolution metrics, i.e. with the
+// StorageResolution set to 1 second. The caller's datums are not modified.
+//
+// High-resolution metrics are billed the same as standard ones, but alarms on
+// them evaluating periods below a minute cost more, and sending sub-minute
+// data means more datums and thus more PutMetricData requests. Data points of
+// 1 second resolution are only retained for 3 hours, after that they are
+// aggregated to coarser periods.
+func (b *Batch) AddHighRes(namespace string, data ...*cw.MetricDatum) *Batch {
+	highRes := make([]*cw.MetricDatum, len(data))
+
+	for i, d := range data {
+		if d != nil {
+			c := *d
+			c.StorageResolution = aws.Int64(1)
+			d = &c
+		}
+
+		highRes[i] = d
+	}
+
+	b.Add(namespace, highRes...)
 
 	return b
 }
 
 func (b *Batch) AddInputs(inputs ...*cw.PutMetricDataInput) *Batch {
 	for _, i := range inputs {
-		b.add(i)
+		_ = b.add(context.Background(), i)
 	}
 
+	b.checkGlobalThreshold()
+
 	return b
 }
 
-func (b *Batch) add(input *cw.PutMetricDataInput) {
+func (b *Batch) add(ctx context.Context, input *cw.PutMetricDataInput) error {
+	input = b.sample(input)
+
 	b.Lock()
-	defer b.Unlock()
 
+	if err := b.waitForSpace(ctx, aws.StringValue(input.Namespace)); err != nil {
+		b.Unlock()
+		return err
+	}
+
+	errs := b.addLocked(input)
+	b.remember(aws.StringValue(input.Namespace), ctx)
+	b.Unlock()
+
+	for _, err := range errs {
+		b.reportError(err)
+	}
+
+	return nil
+}
+
+// addLocked queues the datums of the input. The datums that are rejected are
+// dropped and their errors returned. Must be called with the lock held.
+func (b *Batch) addLocked(input *cw.PutMetricDataInput) []error {
 	ns := aws.StringValue(input.Namespace)
 
+	q := b.queue(ns)
+	count := q.count
+
+	pushed := 0
+
+	var (
+		errs     []error
+		accepted []*cw.MetricDatum
+	)
+
+	for _, datum := range input.MetricData {
+		datum, err := b.prepare(ns, datum)
+		if err != nil {
+			atomic.AddInt64(&b.counters.dropped, 1)
+			errs = append(errs, err)
+
+			continue
+		}
+
+		if b.unchanged(ns, datum) {
+			continue
+		}
+
+		atomic.AddInt64(&b.counters.queued, 1)
+
+		if b.disk != nil {
+			accepted = append(accepted, datum)
+		}
+
+		for _, datum := range b.aggregate(ns, q, datum) {
+			if !b.admit(q) {
+				// the following datums of the metric must not be merged into
+				// the discarded one
+				q.forgetAggregated(datum)
+				continue
+			}
+
+			q.push(datum)
+			pushed++
+		}
+	}
+
+	q.join(pushed)
+
+	atomic.AddInt64(&b.counters.pending, int64(q.count-count))
+
+	if err := b.appendToDiskBuffer(ns, accepted); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// queue returns the queue of the namespace, creating it if needed. Must be
+// called with the lock held.
+func (b *Batch) queue(ns string) *queue {
 	q, ok := b.metricQs[ns]
 	if !ok {
-		q = &queue{
-			nodes: make([]*cw.MetricDatum, maxBatchSize),
-			size:  maxBatchSize,
-		}
+		q = b.newQueue()
 		b.metricQs[ns] = q
 	}
 
-	for _, datum := range input.MetricData {
-		q.push(datum)
+	return q
+}
+
+func (b *Batch) newQueue() *queue {
+	return &queue{
+		nodes:   make([]*cw.MetricDatum, b.batchSize),
+		size:    b.batchSize,
+		grouped: b.preserveInputs,
+		sizeOf:  b.sizeEstimator,
+	}
+}
+
+func (b *Batch) checkGlobalThreshold() {
+	if b.globalThreshold <= 0 || atomic.LoadInt64(&b.counters.pending) <= int64(b.globalThreshold) {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt32(&b.thresholdFlushing, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&b.thresholdFlushing, 0)
+		b.reportError(b.Flush())
+	}()
+}
+
+// prepare returns the datum in the form it should be queued or an error if the
+// datum must be rejected. The caller's datum is never modified, it's copied if
+// any change is needed.
+func (b *Batch) prepare(ns string, datum *cw.MetricDatum) (*cw.MetricDatum, error) {
+	if datum == nil {
+		return nil, &DatumError{Namespace: ns, Reason: "datum is nil"}
+	}
+
+	orig := datum
+	edit := func() *cw.MetricDatum {
+		if datum == orig {
+			d := *orig
+			datum = &d
+		}
+
+		return datum
+	}
+
+	if err := validateDimensions(ns, datum); err != nil {
+		return nil, err
+	}
+
+	datum, err := b.resolveConflict(ns, datum, edit)
+	if err != nil {
+		return nil, err
+	}
+
+	datum = b.clampValues(datum, edit)
+
+	if len(b.defaultDims) > 0 {
+		edit().Dimensions = mergeDimensions(datum.Dimensions, b.defaultDims)
+	}
+
+	datum, err = b.limitDimensions(ns, datum, edit)
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix := b.namePrefixes[ns]; prefix != "" && !strings.HasPrefix(aws.StringValue(datum.MetricName), prefix) {
+		edit().MetricName = aws.String(prefix + aws.StringValue(datum.MetricName))
+		b.modified("NamePrefixed")
+	}
+
+	if b.timestampJitter > 0 {
+		ts := time.Now()
+		if datum.Timestamp != nil {
+			ts = *datum.Timestamp
+		}
+
+		edit().Timestamp = aws.Time(jitter(ts, b.timestampJitter, resolution(datum)))
+		b.modified("TimestampJittered")
+	}
+
+	if b.timestampBucket > 0 {
+		ts := time.Now()
+		if datum.Timestamp != nil {
+			ts = *datum.Timestamp
+		}
+
+		if bucket := ts.Truncate(b.timestampBucket); datum.Timestamp == nil || !bucket.Equal(ts) {
+			edit().Timestamp = aws.Time(bucket)
+			b.modified("TimestampBucketed")
+		}
+	}
+
+	if b.isPercentile(ns, datum) {
+		if datum.StatisticValues != nil {
+			return nil, &DatumError{Namespace: ns, Datum: orig, Reason: "percentile metric can't be sent as a statistic set"}
+		}
+
+		if datum.Value != nil {
+			edit().Values = []*float64{datum.Value}
+			datum.Counts = []*float64{aws.Float64(1)}
+			datum.Value = nil
+
+			b.modified("PercentileConverted")
+		}
+	}
+
+	if b.validation {
+		if err := validate(ns, datum); err != nil {
+			return nil, err
+		}
 	}
+
+	return datum, nil
 }
 
 // FlushCompleteBatches flushes completed batches. The batch is completed if it
-// has exactly 20 MetricDatum items. 20 is a max number of items aws allows to
-// send in one request.
+// has exactly 20 MetricDatum items (or the number set by WithMaxBatchSize). 20
+// is a max number of items aws allows to send in one request.
 func (b *Batch) FlushCompleteBatches() error {
 	return b.FlushCompleteBatchesCtx(context.Background())
 }
 
 func (b *Batch) FlushCompleteBatchesCtx(ctx context.Context) error {
-	errGroup, ctx := errgroup.WithContext(ctx)
-	flush := flush{cwAPI: b.cwAPI, errGroup: errGroup}
+	ctx, cancel, err := b.flushContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	flush, ctx := b.newFlush(ctx, b.send)
 
 	b.Lock()
-	for ns, q := range b.metricQs {
-		for q.count >= maxBatchSize {
-			flush.do(ctx, ns, q.top(maxBatchSize))
-		}
-	}
+	b.dispatch(ctx, flush, b.metricQs, b.batchSize)
+	b.madeSpace()
 	b.Unlock()
 
-	return flush.wait()
+	return b.finish(flush)
 }
 
-// Flush all the collected metrics.
+// Flush all the collected metrics. A failed request doesn't stop the others,
+// if several of them fail, the returned error lists all of them (see
+// FlushError).
 func (b *Batch) Flush() error {
 	return b.FlushCtx(context.Background())
 }
 
 func (b *Batch) FlushCtx(ctx context.Context) error {
+	_, err := b.FlushCtxN(ctx)
+	return err
+}
+
+// FlushWithTimeout flushes all the collected metrics, giving up after d. The
+// requests still in flight are cancelled then and context.DeadlineExceeded is
+// returned, the metrics that haven't been sent stay buffered. It's meant for
+// shutdown hooks that must not block forever.
+func (b *Batch) FlushWithTimeout(d time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+
+	err := b.FlushCtx(ctx)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// FlushCtxN flushes all the collected metrics the same way FlushCtx does and
+// returns the number of datums sent successfully.
+func (b *Batch) FlushCtxN(ctx context.Context) (int, error) {
+	return b.flushTo(ctx, b.send)
+}
+
+// SendFunc sends one PutMetricData request.
+type SendFunc func(ctx context.Context, input *cw.PutMetricDataInput) error
+
+// FlushTo flushes all the collected metrics the same way FlushCtx does, but
+// hands every request over to fn instead of sending it to CloudWatch. The
+// batching and splitting is done by the batch, fn is called once per request
+// (concurrently). This allows adding custom logging, instrumentation or
+// transport per request. Errors returned by fn are handled the same way as the
+// errors of the CloudWatch client.
+func (b *Batch) FlushTo(ctx context.Context, fn SendFunc) error {
+	_, err := b.flushTo(ctx, fn)
+	return err
+}
+
+func (b *Batch) flushTo(ctx context.Context, fn SendFunc) (int, error) {
+	ctx, cancel, err := b.flushContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+
 	b.Lock()
+	errs := b.collectCounters()
+	errs = append(errs, b.collectGauges()...)
+	errs = append(errs, b.collectTimings()...)
+	b.collectInternal()
+	b.collectLiveness()
 	metricQs := b.metricQs
 	b.metricQs = map[string]*queue{}
+	b.madeSpace()
 	b.Unlock()
 
-	errGroup, ctx := errgroup.WithContext(ctx)
-	flush := flush{cwAPI: b.cwAPI, errGroup: errGroup}
+	for _, err := range errs {
+		b.reportError(err)
+	}
+
+	flush, ctx := b.newFlush(ctx, fn)
+
+	b.dispatch(ctx, flush, metricQs, 1)
 
+	// the flush has been cut short, the metrics that weren't dispatched go
+	// back to the buffer
 	for ns, q := range metricQs {
-		for q.count > 0 {
-			flush.do(ctx, ns, q.top(maxBatchSize))
+		if q.count > 0 {
+			b.restore(ns, q)
 		}
 	}
 
-	return flush.wait()
+	return b.finishN(flush)
+}
+
+// Reset discards all the collected metrics without sending them and returns
+// their number. The discarded metrics are lost: they are neither sent nor
+// counted in Stats.Dropped. The values remembered for WithEmitOnChange are
+// forgotten as well, so the next value of every metric is emitted. With
+// WithDiskBuffer, the metrics are removed from the disk as well.
+func (b *Batch) Reset() int {
+	b.Lock()
+
+	n := 0
+	for _, q := range b.metricQs {
+		n += q.count
+		q.each(b.forgetAttempt)
+	}
+
+	b.metricQs = map[string]*queue{}
+
+	for ns := range b.lastValues {
+		b.lastValues[ns] = map[string]lastValue{}
+	}
+
+	atomic.AddInt64(&b.counters.pending, -int64(n))
+	b.madeSpace()
+
+	err := b.rewriteDiskBuffer()
+
+	b.Unlock()
+
+	b.reportError(err)
+
+	return n
+}
+
+// FlushNamespace flushes all the collected metrics of the namespace, leaving
+// the other namespaces untouched. It's a no-op if the namespace has no metrics.
+func (b *Batch) FlushNamespace(ctx context.Context, namespace string) error {
+	ctx, cancel, err := b.flushContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	b.Lock()
+	q, ok := b.metricQs[namespace]
+	if !ok || q.count == 0 {
+		b.Unlock()
+		return nil
+	}
+
+	delete(b.metricQs, namespace)
+	b.madeSpace()
+	b.Unlock()
+
+	flush, ctx := b.newFlush(ctx, b.send)
+
+	b.dispatch(ctx, flush, map[string]*queue{namespace: q}, 1)
+
+	if q.count > 0 {
+		b.restore(namespace, q)
+	}
+
+	return b.finish(flush)
 }
 
+// finalFlushTimeout limits the flush a background job performs when its context
+// is cancelled.
+const finalFlushTimeout = 5 * time.Second
+
 // LaunchAutoFlush creates a background job that auto-flushes metrics
-// periodically. onError is an optional parameter (nil can be provided).
+// periodically. onError is an optional parameter (nil can be provided). When ctx
+// is cancelled, the job flushes the remaining metrics one last time (giving up
+// after 5 seconds) before it stops.
 func (b *Batch) LaunchAutoFlush(ctx context.Context, interval time.Duration, onError func(error)) {
-	go NewTicker(ctx, interval, func() {
-		err := b.FlushCtx(ctx)
-		if onError != nil {
-			onError(err)
-		}
-	})
+	go b.autoFlush(ctx, interval, onError)
+}
+
+// FlushEvery works the same way LaunchAutoFlush does, except that the
+// background job is stopped with the returned function rather than with a
+// context. stop flushes the remaining metrics one last time and waits for the
+// job to finish. It's safe to call stop more than once.
+func (b *Batch) FlushEvery(interval time.Duration, onError func(error)) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		b.autoFlush(ctx, interval, onError)
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
 }
 
 type queue struct {
@@ -140,9 +714,35 @@ type queue struct {
 	head  int
 	tail  int
 	count int
+
+	// groups holds the sizes of the groups of nodes that should be sent in
+	// one request, starting from the head. It's only maintained if grouped is
+	// set, in which case the sizes sum up to count.
+	grouped bool
+	groups  []int
+
+	// aggregated holds the queued datums the datums of the same metric are
+	// merged into, see WithAggregation.
+	aggregated map[string]*aggregated
+
+	// sizeOf estimates the serialized size of a datum, see WithSizeEstimator.
+	// datumSize is used if it's nil.
+	sizeOf func(*cw.MetricDatum) int
+
+	// ctx is the most recent context the datums have been added with, see
+	// WithContextPropagation.
+	ctx context.Context
 }
 
 func (q *queue) push(n *cw.MetricDatum) {
+	q.pushTail(n)
+
+	if q.grouped {
+		q.groups = append(q.groups, 1)
+	}
+}
+
+func (q *queue) pushTail(n *cw.MetricDatum) {
 	if q.head == q.tail && q.count > 0 {
 		nodes := make([]*cw.MetricDatum, len(q.nodes)+q.size)
 		copy(nodes, q.nodes[q.head:])
@@ -157,6 +757,56 @@ func (q *queue) push(n *cw.MetricDatum) {
 	q.count++
 }
 
+func (q *queue) pushFront(n *cw.MetricDatum) {
+	// pushTail makes sure there is room and accounts for the node, which is
+	// then moved from the tail to the head
+	q.pushTail(n)
+
+	q.tail = (q.tail - 1 + len(q.nodes)) % len(q.nodes)
+	q.nodes[q.tail] = nil
+	q.head = (q.head - 1 + len(q.nodes)) % len(q.nodes)
+	q.nodes[q.head] = n
+
+	if q.grouped {
+		q.groups = append([]int{1}, q.groups...)
+	}
+}
+
+// join makes a single group out of the last n pushed nodes.
+func (q *queue) join(n int) {
+	// the oldest nodes may have been dropped to make room for the new ones
+	if n > len(q.groups) {
+		n = len(q.groups)
+	}
+
+	if !q.grouped || n < 2 {
+		return
+	}
+
+	q.groups = append(q.groups[:len(q.groups)-n], n)
+}
+
+// moveFrom pops all the nodes from the other queue and pushes them to this
+// one, keeping their grouping.
+func (q *queue) moveFrom(other *queue) {
+	if !q.grouped || !other.grouped {
+		for other.count > 0 {
+			q.push(other.pop())
+		}
+
+		return
+	}
+
+	for other.count > 0 {
+		n := other.groups[0]
+		for i := 0; i < n; i++ {
+			q.push(other.pop())
+		}
+
+		q.join(n)
+	}
+}
+
 func (q *queue) pop() *cw.MetricDatum {
 	if q.count == 0 {
 		return nil
@@ -166,14 +816,59 @@ func (q *queue) pop() *cw.MetricDatum {
 	q.head = (q.head + 1) % len(q.nodes)
 	q.count--
 
+	// a popped datum may be being sent, it must not be aggregated into anymore
+	q.aggregated = nil
+
+	if q.grouped {
+		q.groups[0]--
+		if q.groups[0] == 0 {
+			q.groups = q.groups[1:]
+		}
+	}
+
 	return node
 }
 
+// each calls fn for every node from the head to the tail without popping them.
+func (q *queue) each(fn func(*cw.MetricDatum)) {
+	for i := 0; i < q.count; i++ {
+		fn(q.nodes[(q.head+i)%len(q.nodes)])
+	}
+}
+
+// top pops up to n nodes, as many as fit into one request (see maxRequestSize
+// and maxRequestValues). If the queue is grouped, only whole groups are popped
+// as long as they fit, a group larger than n is split.
 func (q *queue) top(n int) []*cw.MetricDatum {
 	if q.count < n {
 		n = q.count
 	}
 
+	if q.grouped && len(q.groups) > 0 && q.groups[0] < n {
+		take := 0
+		for _, g := range q.groups {
+			if take+g > n {
+				break
+			}
+			take += g
+		}
+
+		n = take
+	}
+
+	size, values := 0, 0
+
+	for i := 0; i < n; i++ {
+		d := q.nodes[(q.head+i)%len(q.nodes)]
+		size += q.datumSize(d)
+		values += datumValues(d)
+
+		if (size > maxRequestSize || values > maxRequestValues) && i > 0 {
+			n = i
+			break
+		}
+	}
+
 	result := make([]*cw.MetricDatum, 0, n)
 
 	for i := 0; i < n; i++ {
@@ -183,32 +878,383 @@ func (q *queue) top(n int) []*cw.MetricDatum {
 	return result
 }
 
+// roundRobin pops batches of up to size metrics, one batch per namespace in
+// rotation, and passes them to fn. This way every namespace gets some of its
+// data out even if the flush doesn't manage to send everything before the
+// deadline. The rotation visits the namespaces in the given order, queues
+// holding less than min metrics are left untouched. The rotation stops once fn
+// returns false, roundRobin reports whether it ran to the end.
+func roundRobin(
+	metricQs map[string]*queue, namespaces []string, size, min int, fn func(ns string, batch []*cw.MetricDatum) bool,
+) bool {
+	namespaces = append([]string(nil), namespaces...)
+
+	for len(namespaces) > 0 {
+		pending := namespaces[:0]
+
+		for _, ns := range namespaces {
+			q, ok := metricQs[ns]
+			if !ok || q.count < min {
+				continue
+			}
+
+			if !fn(ns, q.top(size)) {
+				return false
+			}
+
+			if q.count >= min {
+				pending = append(pending, ns)
+			}
+		}
+
+		namespaces = pending
+	}
+
+	return true
+}
+
+func (b *Batch) send(ctx context.Context, input *cw.PutMetricDataInput) error {
+	atomic.AddInt64(&b.counters.apiCalls, 1)
+
+	api := b.client(aws.StringValue(input.Namespace))
+
+	if atomic.LoadInt32(&b.compress) == 1 {
+		err := b.put(ctx, api, input, gzipRequest)
+		if !isCompressionRejected(err) {
+			return err
+		}
+
+		atomic.StoreInt32(&b.compress, 0)
+		atomic.AddInt64(&b.counters.apiCalls, 1)
+	}
+
+	return b.put(ctx, api, input)
+}
+
+// put makes one PutMetricData call.
+func (b *Batch) put(
+	ctx context.Context, api cloudwatchiface.CloudWatchAPI, input *cw.PutMetricDataInput, opts ...request.Option,
+) error {
+	if b.onFlushLatency == nil {
+		_, err := api.PutMetricDataWithContext(ctx, input, opts...)
+		return err
+	}
+
+	start := time.Now()
+	_, err := api.PutMetricDataWithContext(ctx, input, opts...)
+	b.onFlushLatency(time.Since(start), len(input.MetricData))
+
+	return err
+}
+
+// dispatch hands the batches from the queues over to the flush. Queues holding
+// less than min metrics are left untouched. Dispatching stops as soon as the
+// context is done, the remaining metrics are left in the queues. The queues
+// are compacted first, see WithCompaction.
+func (b *Batch) dispatch(ctx context.Context, flush *flush, metricQs map[string]*queue, min int) {
+	for ns, q := range metricQs {
+		b.compact(ns, q)
+	}
+
+	for _, namespaces := range b.flushGroups(metricQs) {
+		done := roundRobin(metricQs, namespaces, b.batchSize, min, func(ns string, batch []*cw.MetricDatum) bool {
+			if err := ctx.Err(); err != nil {
+				flush.interrupted = err
+
+				// the batch goes back to where it was taken from
+				q := metricQs[ns]
+				for i := len(batch) - 1; i >= 0; i-- {
+					q.pushFront(batch[i])
+				}
+
+				return false
+			}
+
+			atomic.AddInt64(&b.counters.pending, -int64(len(batch)))
+			flush.do(requestContext(ctx, metricQs[ns]), ns, batch)
+
+			return true
+		})
+		if !done {
+			return
+		}
+	}
+}
+
+// restore puts the queue taken out of the buffer back, in front of the metrics
+// added to the namespace meanwhile.
+func (b *Batch) restore(ns string, q *queue) {
+	b.Lock()
+	defer b.Unlock()
+
+	if newer, ok := b.metricQs[ns]; ok {
+		q.moveFrom(newer)
+
+		if newer.ctx != nil {
+			q.ctx = newer.ctx
+		}
+	}
+
+	// the pending counter still includes the metrics of the queue, it's only
+	// decreased by the metrics dispatched
+	b.metricQs[ns] = q
+}
+
+// requeue puts the batch that couldn't be sent back to the front of the queue.
+func (b *Batch) requeue(ns string, batch []*cw.MetricDatum) {
+	b.Lock()
+	defer b.Unlock()
+
+	q := b.queue(ns)
+	for i := len(batch) - 1; i >= 0; i-- {
+		q.pushFront(batch[i])
+	}
+
+	atomic.AddInt64(&b.counters.pending, int64(len(batch)))
+}
+
+// finish waits for the flush to complete and records its outcome.
+func (b *Batch) finish(flush *flush) error {
+	_, err := b.finishN(flush)
+	return err
+}
+
+// finishN is finish returning the number of datums sent by the flush as well.
+func (b *Batch) finishN(flush *flush) (int, error) {
+	err := flush.wait()
+	sent := atomic.LoadInt64(&flush.sent)
+
+	b.syncDiskBuffer()
+
+	atomic.StoreInt64(&b.counters.lastFlushSent, sent)
+	atomic.StoreInt64(&b.counters.lastFlushAt, time.Now().UnixNano())
+
+	b.logger.Debug("cwatsch: flush finished", "sent", sent, "duration", time.Since(flush.started),
+		"pending", atomic.LoadInt64(&b.counters.pending), "error", err)
+
+	return int(sent), err
+}
+
+func (b *Batch) newFlush(ctx context.Context, send SendFunc) (*flush, context.Context) {
+	// a failed request doesn't cancel the others, so that the flush reports
+	// all the failures
+	errGroup := &errgroup.Group{}
+
+	return &flush{
+		send:        b.retrying(send),
+		requeue:     b.requeue,
+		failed:      b.requeueFailed,
+		deadLetter:  b.sendToDeadLetter,
+		reportError: b.reportFlushError,
+		forget:      b.forgetAttempts,
+		check:       b.checkTimestamps,
+		observe:     b.observeNamespace,
+		counters:    &b.counters,
+		errGroup:    errGroup,
+		sem:         b.flushSem,
+		logger:      b.logger,
+		started:     time.Now(),
+	}, ctx
+}
+
 type flush struct {
-	cwAPI    cloudwatchiface.CloudWatchAPI
-	errGroup *errgroup.Group
+	sent        int64 // accessed atomically, kept first for alignment
+	send        SendFunc
+	requeue     func(ns string, batch []*cw.MetricDatum)
+	failed      func(ns string, batch []*cw.MetricDatum) (dropped []*cw.MetricDatum)
+	deadLetter  func(ns string, dropped []*cw.MetricDatum)
+	reportError func(ns string, batch []*cw.MetricDatum, err error)
+	forget      func(batch []*cw.MetricDatum)
+	check       func(ns string, batch []*cw.MetricDatum) []*cw.MetricDatum
+	observe     func(ns string, latency time.Duration, err error)
+	counters    *counters
+	errGroup    *errgroup.Group
+	sem         chan struct{}
+	// turn is closed once the request dispatched last has got its slot in
+	// sem, so that the requests get the slots in the order they are
+	// dispatched and the rotation of the namespaces is kept
+	turn    chan struct{}
+	logger  Logger
+	started time.Time
+	// interrupted is the error of the context that stopped the dispatch
+	// before all the batches were taken
+	interrupted error
+
+	failuresMu sync.Mutex
+	failures   []error
+}
+
+// acquire takes a slot in sem once the request dispatched before has got its
+// one. It reports false if ctx is done first.
+func (f *flush) acquire(ctx context.Context, prev chan struct{}) bool {
+	if prev != nil {
+		select {
+		case <-prev:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	select {
+	case f.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
+// do sends the batch in the background. It must not be called concurrently.
 func (f *flush) do(ctx context.Context, ns string, batch []*cw.MetricDatum) {
+	var prev, next chan struct{}
+	if f.sem != nil {
+		prev, next = f.turn, make(chan struct{})
+		f.turn = next
+	}
+
 	f.errGroup.Go(func() error {
-		_, err := f.cwAPI.PutMetricDataWithContext(ctx, &cw.PutMetricDataInput{
+		// the batch is kept if the flush has been cancelled before (or while)
+		// sending it
+		if err := ctx.Err(); err != nil && f.requeue != nil {
+			if next != nil {
+				close(next)
+			}
+
+			f.requeue(ns, batch)
+
+			return err
+		}
+
+		if f.sem != nil {
+			acquired := f.acquire(ctx, prev)
+			close(next)
+
+			if !acquired {
+				if f.requeue != nil {
+					f.requeue(ns, batch)
+				}
+
+				return ctx.Err()
+			}
+
+			defer func() { <-f.sem }()
+		}
+
+		if f.check != nil {
+			if batch = f.check(ns, batch); len(batch) == 0 {
+				return nil
+			}
+		}
+
+		start := time.Now()
+		err := f.send(ctx, &cw.PutMetricDataInput{
 			Namespace:  aws.String(ns),
-			MetricData: batch,
+			MetricData: shareDimensions(batch),
 		})
-		return err
+		duration := time.Since(start)
+		f.observe(ns, duration, err)
+
+		if err != nil && ctx.Err() != nil && f.requeue != nil {
+			f.logger.Debug("cwatsch: request cancelled, metrics kept", "namespace", ns, "size", len(batch), "error", err)
+			f.requeue(ns, batch)
+
+			return err
+		}
+
+		if err != nil {
+			f.logger.Error("cwatsch: request failed", "namespace", ns, "size", len(batch), "duration", duration, "error", err)
+			f.reportError(ns, batch, err)
+			f.fail(ns, err)
+
+			dropped := batch
+			if f.failed != nil {
+				dropped = f.failed(ns, batch)
+			}
+
+			atomic.AddInt64(&f.counters.flushErrors, 1)
+			atomic.AddInt64(&f.counters.dropped, int64(len(dropped)))
+
+			if f.forget != nil {
+				f.forget(dropped)
+			}
+
+			if len(dropped) > 0 && f.deadLetter != nil {
+				f.deadLetter(ns, dropped)
+			}
+
+			return err
+		}
+
+		if f.forget != nil {
+			f.forget(batch)
+		}
+
+		atomic.AddInt64(&f.counters.metricsSent, int64(len(batch)))
+		atomic.AddInt64(&f.sent, int64(len(batch)))
+		f.logger.Debug("cwatsch: request sent", "namespace", ns, "size", len(batch), "duration", duration)
+
+		return nil
 	})
 }
 
+// fail records the failure of a request.
+func (f *flush) fail(ns string, err error) {
+	f.failuresMu.Lock()
+	defer f.failuresMu.Unlock()
+
+	f.failures = append(f.failures, &FlushError{Namespace: ns, Err: err})
+}
+
+// wait waits for the requests of the flush to complete. If several of them
+// failed, all their errors are returned joined, otherwise the error of the
+// failed request (or of the cancellation) as it is.
 func (f *flush) wait() error {
-	return f.errGroup.Wait()
+	err := f.errGroup.Wait()
+
+	f.failuresMu.Lock()
+	defer f.failuresMu.Unlock()
+
+	if len(f.failures) > 1 {
+		return errors.Join(f.failures...)
+	}
+
+	if err == nil {
+		err = f.interrupted
+	}
+
+	return err
 }
 
+// NewTicker calls fn every interval until ctx is done. The calls follow a fixed
+// schedule regardless of how long fn takes. If fn is still running when a tick
+// is due, the tick is dropped, so the calls never pile up.
 func NewTicker(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case <-time.After(interval):
+		case <-ticker.C:
 			fn()
+
+			// drop the tick that became due while fn was running
+			select {
+			case <-ticker.C:
+			default:
+			}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+// NewTickerImmediate calls fn right away and then works the same way NewTicker
+// does, so the first data point doesn't have to wait for a whole interval. fn
+// isn't called at all if ctx is already done.
+func NewTickerImmediate(ctx context.Context, interval time.Duration, fn func()) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	fn()
+	NewTicker(ctx, interval, fn)
+}
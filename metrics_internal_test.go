@@ -0,0 +1,367 @@
+package cwatsch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestQueue(n int) *queue {
+	q := &queue{nodes: make([]*cw.MetricDatum, maxBatchSize), size: maxBatchSize}
+	for i := 0; i < n; i++ {
+		q.push(&cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("metric%d", i))})
+	}
+
+	return q
+}
+
+func TestRoundRobinInterleavesNamespaces(t *testing.T) {
+	metricQs := map[string]*queue{}
+	for i := 0; i < 10; i++ {
+		metricQs[fmt.Sprintf("namespace%d", i)] = newTestQueue(5 * maxBatchSize)
+	}
+
+	// a slow sender that can only afford to send as many requests as there
+	// are namespaces before the deadline hits
+	budget := len(metricQs)
+	sent := map[string]int{}
+
+	roundRobin(metricQs, namespacesOf(metricQs), maxBatchSize, 1, func(ns string, batch []*cw.MetricDatum) bool {
+		sent[ns] += len(batch)
+		budget--
+
+		return budget > 0
+	})
+
+	assert.Len(t, sent, len(metricQs))
+
+	for ns, n := range sent {
+		assert.Equal(t, maxBatchSize, n, ns)
+	}
+}
+
+func TestRoundRobinDrainsUnevenQueues(t *testing.T) {
+	metricQs := map[string]*queue{
+		"small": newTestQueue(3),
+		"large": newTestQueue(3*maxBatchSize + 1),
+	}
+
+	var order []string
+
+	roundRobin(metricQs, namespacesOf(metricQs), maxBatchSize, 1, func(ns string, batch []*cw.MetricDatum) bool {
+		order = append(order, fmt.Sprintf("%s:%d", ns, len(batch)))
+		return true
+	})
+
+	assert.ElementsMatch(t, []string{"small:3", "large:20", "large:20", "large:20", "large:1"}, order)
+	assert.Contains(t, order[:2], "small:3")
+	assert.Equal(t, 0, metricQs["small"].count)
+	assert.Equal(t, 0, metricQs["large"].count)
+}
+
+func TestRoundRobinSkipsIncompleteBatches(t *testing.T) {
+	metricQs := map[string]*queue{
+		"complete":   newTestQueue(maxBatchSize + 5),
+		"incomplete": newTestQueue(maxBatchSize - 1),
+	}
+
+	var sent []string
+
+	roundRobin(metricQs, namespacesOf(metricQs), maxBatchSize, maxBatchSize, func(ns string, batch []*cw.MetricDatum) bool {
+		sent = append(sent, ns)
+		return true
+	})
+
+	assert.Equal(t, []string{"complete"}, sent)
+	assert.Equal(t, 5, metricQs["complete"].count)
+	assert.Equal(t, maxBatchSize-1, metricQs["incomplete"].count)
+}
+
+func TestFlushOrder(t *testing.T) {
+	newQueues := func() map[string]*queue {
+		return map[string]*queue{
+			"b":        newTestQueue(maxBatchSize + 1),
+			"critical": newTestQueue(2*maxBatchSize + 1),
+			"a":        newTestQueue(maxBatchSize + 1),
+			"c":        newTestQueue(1),
+		}
+	}
+
+	b := New(nil, WithFlushOrder("critical", "missing", "critical"))
+
+	var sent []string
+
+	for _, namespaces := range b.flushGroups(newQueues()) {
+		sent = append(sent, namespaces...)
+	}
+
+	assert.Equal(t, []string{"critical", "a", "b", "c"}, sent)
+
+	metricQs := newQueues()
+	sent = nil
+
+	for _, namespaces := range b.flushGroups(metricQs) {
+		roundRobin(metricQs, namespaces, maxBatchSize, 1, func(ns string, batch []*cw.MetricDatum) bool {
+			sent = append(sent, fmt.Sprintf("%s:%d", ns, len(batch)))
+			return true
+		})
+	}
+
+	assert.Equal(t, []string{
+		"critical:20", "critical:20", "critical:1",
+		"a:20", "b:20", "c:1", "a:1", "b:1",
+	}, sent)
+
+	assert.Len(t, New(nil).flushGroups(newQueues()), 1, "the namespaces aren't grouped by default")
+}
+
+func TestQueuePushFront(t *testing.T) {
+	q := newTestQueue(maxBatchSize)
+	q.top(5)
+
+	for i := 0; i < 10; i++ {
+		q.pushFront(&cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("front%d", i))})
+	}
+
+	names := []string{}
+	for q.count > 0 {
+		names = append(names, aws.StringValue(q.pop().MetricName))
+	}
+
+	require.Len(t, names, maxBatchSize+5)
+	assert.Equal(t, []string{"front9", "front8"}, names[:2])
+	assert.Equal(t, []string{"front0", "metric5"}, names[9:11])
+	assert.Equal(t, fmt.Sprintf("metric%d", maxBatchSize-1), names[len(names)-1])
+}
+
+type sendRecorder struct {
+	sync.Mutex
+	inputs []*cw.PutMetricDataInput
+}
+
+func (r *sendRecorder) send(_ context.Context, input *cw.PutMetricDataInput) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.inputs = append(r.inputs, input)
+
+	return nil
+}
+
+func TestInternalMetricsCountModifications(t *testing.T) {
+	b := New(nil, WithInternalMetrics("cwatsch"))
+
+	b.modified("DimensionsTruncated")
+	b.modified("TimestampClamped")
+	b.modified("DimensionsTruncated")
+
+	rec := sendRecorder{}
+	require.NoError(t, b.FlushTo(context.Background(), rec.send))
+
+	require.Len(t, rec.inputs, 1)
+	assert.Equal(t, "cwatsch", aws.StringValue(rec.inputs[0].Namespace))
+
+	data := rec.inputs[0].MetricData
+	require.Len(t, data, 2)
+
+	for i, expected := range []struct {
+		kind  string
+		value float64
+	}{{"DimensionsTruncated", 2}, {"TimestampClamped", 1}} {
+		assert.Equal(t, "MetricsModified", aws.StringValue(data[i].MetricName))
+		assert.Equal(t, expected.value, aws.Float64Value(data[i].Value))
+		assert.Equal(t, []*cw.Dimension{{
+			Name:  aws.String("Modification"),
+			Value: aws.String(expected.kind),
+		}}, data[i].Dimensions)
+	}
+
+	assert.Equal(t, int64(3), b.Stats().Modified)
+
+	rec.inputs = nil
+	require.NoError(t, b.FlushTo(context.Background(), rec.send))
+	assert.Empty(t, rec.inputs, "counts are reset after being reported")
+}
+
+func TestInternalMetricsCountLaterTransforms(t *testing.T) {
+	b := New(nil,
+		WithInternalMetrics("cwatsch"),
+		WithNamePrefix("myApp", "app_"),
+		WithTimestampBucket(time.Minute),
+		WithPercentiles("myApp", "app_latency"),
+	)
+
+	ts := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	b.Add("myApp",
+		Datum("latency").Value(1).At(ts.Add(time.Second)).Build(),
+		Datum("app_calls").Value(1).At(ts).Build(),
+	)
+	assert.Equal(t, int64(3), b.Stats().Modified)
+
+	rec := sendRecorder{}
+	require.NoError(t, b.FlushTo(context.Background(), rec.send))
+
+	modifications := map[string]float64{}
+
+	for _, input := range rec.inputs {
+		if aws.StringValue(input.Namespace) != "cwatsch" {
+			continue
+		}
+
+		for _, d := range input.MetricData {
+			modifications[aws.StringValue(d.Dimensions[0].Value)] = aws.Float64Value(d.Value)
+		}
+	}
+
+	assert.Equal(t, map[string]float64{
+		"NamePrefixed":        1,
+		"TimestampBucketed":   1,
+		"PercentileConverted": 1,
+	}, modifications)
+}
+
+func TestInternalMetricsAreNotModified(t *testing.T) {
+	b := New(nil,
+		WithInternalMetrics("cwatsch"),
+		WithTimestampBucket(time.Minute),
+		WithTimestampJitter(time.Second),
+	)
+
+	b.Add("myApp", Datum("calls").Value(1).At(time.Now().Add(-time.Hour).Truncate(time.Minute).Add(time.Second)).Build())
+
+	modified := func() []*cw.MetricDatum {
+		rec := sendRecorder{}
+		require.NoError(t, b.FlushTo(context.Background(), rec.send))
+
+		data := []*cw.MetricDatum{}
+
+		for _, input := range rec.inputs {
+			if aws.StringValue(input.Namespace) == "cwatsch" {
+				data = append(data, input.MetricData...)
+			}
+		}
+
+		return data
+	}
+
+	assert.NotEmpty(t, modified())
+	assert.Empty(t, modified(), "the internal datums don't count as modified")
+	assert.Empty(t, modified())
+}
+
+func TestGroupedQueueKeepsGroupsWhenMoved(t *testing.T) {
+	q := &queue{nodes: make([]*cw.MetricDatum, maxBatchSize), size: maxBatchSize, grouped: true}
+	other := &queue{nodes: make([]*cw.MetricDatum, maxBatchSize), size: maxBatchSize, grouped: true}
+
+	for i := 0; i < 3; i++ {
+		q.push(&cw.MetricDatum{})
+	}
+	q.join(3)
+
+	for i := 0; i < 15; i++ {
+		other.push(&cw.MetricDatum{})
+	}
+	other.join(15)
+	other.push(&cw.MetricDatum{})
+
+	q.pushFront(&cw.MetricDatum{})
+	q.moveFrom(other)
+
+	assert.Equal(t, []int{1, 3, 15, 1}, q.groups)
+	assert.Equal(t, 20, q.count)
+	assert.Equal(t, 0, other.count)
+
+	assert.Len(t, q.top(18), 4)
+	assert.Len(t, q.top(18), 16)
+	assert.Empty(t, q.groups)
+}
+
+func TestLivenessMetricIsSentOnEveryFlush(t *testing.T) {
+	b := New(nil,
+		WithLivenessMetric("cwatsch", "FlushAlive"),
+		WithEmitOnChange("cwatsch", time.Hour),
+	)
+
+	for i := 0; i < 2; i++ {
+		rec := sendRecorder{}
+		require.NoError(t, b.FlushTo(context.Background(), rec.send))
+
+		require.Len(t, rec.inputs, 1)
+		assert.Equal(t, "cwatsch", aws.StringValue(rec.inputs[0].Namespace))
+		require.Len(t, rec.inputs[0].MetricData, 1)
+
+		datum := rec.inputs[0].MetricData[0]
+		assert.Equal(t, "FlushAlive", aws.StringValue(datum.MetricName))
+		assert.Equal(t, 1.0, aws.Float64Value(datum.Value))
+		assert.Equal(t, cw.StandardUnitCount, aws.StringValue(datum.Unit))
+	}
+}
+
+func TestFailureBackoff(t *testing.T) {
+	b := New(nil, WithFailureBackoff(time.Minute))
+	fail := errors.New("fail")
+
+	delay := 10 * time.Second
+	delays := []time.Duration{}
+
+	for _, err := range []error{fail, fail, fail, fail, nil, fail} {
+		delay = b.nextFlushDelay(delay, 10*time.Second, err)
+		delays = append(delays, delay)
+	}
+
+	assert.Equal(t, []time.Duration{
+		20 * time.Second, 40 * time.Second, time.Minute, time.Minute, 10 * time.Second, 20 * time.Second,
+	}, delays)
+
+	assert.Equal(t, time.Second, New(nil).nextFlushDelay(time.Second, time.Second, fail), "no backoff by default")
+}
+
+func TestFlushJitter(t *testing.T) {
+	b := New(nil)
+
+	seen := map[time.Duration]bool{}
+
+	for i := 0; i < 100; i++ {
+		d := b.jittered(time.Minute)
+		assert.GreaterOrEqual(t, int64(d), int64(54*time.Second))
+		assert.LessOrEqual(t, int64(d), int64(66*time.Second))
+
+		seen[d] = true
+	}
+
+	assert.Greater(t, len(seen), 1, "the delay is randomized by default")
+
+	b = New(nil, WithFlushJitter(0))
+	assert.Equal(t, time.Minute, b.jittered(time.Minute))
+}
+
+func TestDroppedDatumsForgetTheirAttempts(t *testing.T) {
+	fail := func(context.Context, *cw.PutMetricDataInput) error { return errors.New("fail") }
+
+	b := New(nil, WithRequeueOnError(3))
+	b.Add("myApp", Datum("m").Value(1).Build(), Datum("m").Value(2).Build())
+
+	require.Error(t, b.FlushTo(context.Background(), fail))
+	assert.Len(t, b.attempts, 2)
+
+	assert.Equal(t, 2, b.Reset())
+	assert.Empty(t, b.attempts)
+
+	b = New(nil, WithRequeueOnError(3), WithMaxQueueSize(1, DropOldest))
+	b.Add("myApp", Datum("m").Value(1).Build())
+
+	require.Error(t, b.FlushTo(context.Background(), fail))
+	assert.Len(t, b.attempts, 1)
+
+	b.Add("myApp", Datum("m").Value(2).Build())
+	assert.Empty(t, b.attempts, "the oldest datum is dropped to make room")
+}
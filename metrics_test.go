@@ -2,17 +2,21 @@ package cwatsch_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 	"github.com/molecule-man/cwatsch"
+	"github.com/molecule-man/cwatsch/cwatschtest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +25,7 @@ type cwMock struct {
 	cloudwatchiface.CloudWatchAPI
 	sync.Mutex
 	capturedPayloads []*cw.PutMetricDataInput
+	err              error
 }
 
 var cwAPI = cwMock{}
@@ -31,6 +36,10 @@ func (mock *cwMock) PutMetricDataWithContext(
 	mock.Lock()
 	defer mock.Unlock()
 
+	if mock.err != nil {
+		return nil, mock.err
+	}
+
 	if mock.capturedPayloads == nil {
 		mock.capturedPayloads = []*cw.PutMetricDataInput{}
 	}
@@ -40,6 +49,13 @@ func (mock *cwMock) PutMetricDataWithContext(
 	return nil, nil
 }
 
+func (mock *cwMock) payloads() []*cw.PutMetricDataInput {
+	mock.Lock()
+	defer mock.Unlock()
+
+	return append([]*cw.PutMetricDataInput(nil), mock.capturedPayloads...)
+}
+
 func sortByNS(payloads []*cw.PutMetricDataInput) []*cw.PutMetricDataInput {
 	sort.Slice(payloads, func(i, j int) bool {
 		return aws.StringValue(payloads[i].Namespace) < aws.StringValue(payloads[j].Namespace)
@@ -159,3 +175,627 @@ func TestAutoFlush(t *testing.T) {
 	assert.Len(t, cwAPI.capturedPayloads, 1)
 	assert.Len(t, cwAPI.capturedPayloads[0].MetricData, 10)
 }
+
+func TestAutoFlushFlushesOnCancel(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	batch.LaunchAutoFlush(ctx, time.Hour, nil)
+
+	batch.Add("ns", metricData("metric", 3)...)
+	cancel()
+
+	require.Eventually(t, func() bool { return len(cwAPI.payloads()) == 1 }, time.Second, time.Millisecond)
+	assert.Len(t, cwAPI.payloads()[0].MetricData, 3)
+}
+
+func TestPutMetricDataWithContext(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxQueueSize(2, cwatsch.Block))
+
+	var client interface {
+		PutMetricDataWithContext(aws.Context, *cw.PutMetricDataInput, ...request.Option) (*cw.PutMetricDataOutput, error)
+	} = batch
+
+	_, err := client.PutMetricDataWithContext(context.Background(), &cw.PutMetricDataInput{
+		Namespace:  aws.String("myApp"),
+		MetricData: metricData("metric", 2),
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = client.PutMetricDataWithContext(ctx, &cw.PutMetricDataInput{
+		Namespace:  aws.String("myApp"),
+		MetricData: metricData("blocked", 1),
+	})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Equal(t, metricData("metric", 2), cwAPI.capturedPayloads[0].MetricData)
+}
+
+func TestReset(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithEmitOnChange("config", 0))
+
+	batch.Add("myApp", metricData("metric", 3)...)
+	batch.Add("config", cwatsch.Datum("replicas").Value(1).Build())
+
+	assert.Equal(t, 4, batch.Reset())
+	assert.Equal(t, 0, batch.Len())
+	assert.Equal(t, int64(0), batch.Stats().Pending)
+	assert.Equal(t, int64(0), batch.Stats().Dropped)
+
+	// the discarded value wasn't sent, so it's not considered unchanged
+	batch.Add("config", cwatsch.Datum("replicas").Value(1).Build())
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Equal(t, []float64{1}, sentValues(cwAPI.capturedPayloads))
+
+	assert.Equal(t, 0, batch.Reset())
+}
+
+func TestFlushTo(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	for i := 0; i < 22; i++ {
+		batch.Add("namespace", &cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("metric%d", i))})
+	}
+
+	var (
+		mu     sync.Mutex
+		inputs []*cw.PutMetricDataInput
+	)
+
+	err := batch.FlushTo(context.Background(), func(_ context.Context, input *cw.PutMetricDataInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		inputs = append(inputs, input)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	sortBySize(inputs)
+
+	require.Len(t, inputs, 2)
+	assert.Len(t, inputs[0].MetricData, 20)
+	assert.Len(t, inputs[1].MetricData, 2)
+	assert.Equal(t, "namespace", aws.StringValue(inputs[1].Namespace))
+	assert.Empty(t, cwAPI.capturedPayloads)
+}
+
+func TestFlushToReturnsError(t *testing.T) {
+	batch := cwatsch.New(&cwMock{})
+	batch.Add("namespace", &cw.MetricDatum{MetricName: aws.String("metric")})
+
+	errSend := errors.New("send failed")
+	err := batch.FlushTo(context.Background(), func(context.Context, *cw.PutMetricDataInput) error {
+		return errSend
+	})
+
+	assert.Equal(t, errSend, err)
+}
+
+func TestFlushToReturnsAllErrors(t *testing.T) {
+	batch := cwatsch.New(&cwMock{})
+
+	for i := 0; i < 10; i++ {
+		batch.Add(fmt.Sprintf("namespace%d", i), &cw.MetricDatum{MetricName: aws.String("metric")})
+	}
+
+	errSend := errors.New("send failed")
+	err := batch.FlushTo(context.Background(), func(_ context.Context, input *cw.PutMetricDataInput) error {
+		switch aws.StringValue(input.Namespace) {
+		case "namespace2", "namespace5", "namespace7":
+			return errSend
+		}
+
+		return nil
+	})
+	require.Error(t, err)
+
+	assert.True(t, errors.Is(err, errSend))
+	assert.Equal(t, int64(7), batch.Stats().MetricsSent)
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	require.True(t, ok)
+
+	var namespaces []string
+
+	for _, e := range joined.Unwrap() {
+		var flushErr *cwatsch.FlushError
+
+		require.True(t, errors.As(e, &flushErr))
+		assert.Equal(t, errSend, flushErr.Err)
+
+		namespaces = append(namespaces, flushErr.Namespace)
+	}
+
+	assert.ElementsMatch(t, []string{"namespace2", "namespace5", "namespace7"}, namespaces)
+	assert.Contains(t, err.Error(), `namespace "namespace5"`)
+}
+
+func TestGlobalFlushThreshold(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithGlobalFlushThreshold(30))
+
+	for i := 0; i < 30; i++ {
+		batch.Add(fmt.Sprintf("namespace%d", i%3), &cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("metric%d", i))})
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	assert.Empty(t, cwAPI.payloads())
+
+	batch.Add("namespace0", &cw.MetricDatum{MetricName: aws.String("metric30")})
+
+	assert.Eventually(t, func() bool {
+		return len(cwAPI.payloads()) == 3
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int64(0), batch.Stats().Pending)
+}
+
+func TestGlobalFlushThresholdReportsErrors(t *testing.T) {
+	errs := make(chan error, 1)
+	cwAPI := cwMock{err: errors.New("boom")}
+	batch := cwatsch.New(&cwAPI,
+		cwatsch.WithGlobalFlushThreshold(1),
+		cwatsch.WithOnError(func(err error) { errs <- err }),
+	)
+
+	batch.Add("", &cw.MetricDatum{MetricName: aws.String("metric1")}, &cw.MetricDatum{MetricName: aws.String("metric2")})
+
+	select {
+	case err := <-errs:
+		assert.EqualError(t, err, "boom")
+	case <-time.After(time.Second):
+		t.Fatal("error wasn't reported")
+	}
+}
+
+type cancellingMock struct {
+	cwMock
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (mock *cancellingMock) PutMetricDataWithContext(
+	ctx aws.Context, input *cw.PutMetricDataInput, opts ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mock.once.Do(mock.cancel)
+
+	return mock.cwMock.PutMetricDataWithContext(ctx, input, opts...)
+}
+
+func TestCancelledFlushKeepsUnsentMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cwAPI := cancellingMock{cancel: cancel}
+	batch := cwatsch.New(&cwAPI)
+
+	for i := 0; i < 200; i++ {
+		batch.Add(fmt.Sprintf("namespace%d", i%2), &cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("metric%d", i))})
+	}
+
+	require.Equal(t, context.Canceled, batch.FlushCtx(ctx))
+
+	sent := 0
+	for _, p := range cwAPI.payloads() {
+		sent += len(p.MetricData)
+	}
+
+	assert.Equal(t, int64(200-sent), batch.Stats().Pending)
+	assert.Equal(t, int64(0), batch.Stats().Dropped)
+
+	require.NoError(t, batch.Flush())
+
+	names := map[string]int{}
+	for _, p := range cwAPI.payloads() {
+		for _, d := range p.MetricData {
+			names[aws.StringValue(d.MetricName)]++
+		}
+	}
+
+	assert.Len(t, names, 200)
+
+	for name, n := range names {
+		assert.Equal(t, 1, n, name)
+	}
+}
+
+func metricData(prefix string, n int) []*cw.MetricDatum {
+	data := make([]*cw.MetricDatum, n)
+	for i := range data {
+		data[i] = &cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("%s%d", prefix, i))}
+	}
+
+	return data
+}
+
+func payloadSizes(payloads []*cw.PutMetricDataInput) []int {
+	sizes := make([]int, len(payloads))
+	for i, p := range payloads {
+		sizes[i] = len(p.MetricData)
+	}
+
+	return sizes
+}
+
+func TestPreserveInputBatches(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithPreserveInputBatches())
+
+	batch.AddInputs(
+		&cw.PutMetricDataInput{Namespace: aws.String("ns"), MetricData: metricData("a", 12)},
+		&cw.PutMetricDataInput{Namespace: aws.String("ns"), MetricData: metricData("b", 12)},
+	)
+	batch.Add("ns", metricData("c", 5)...)
+
+	require.NoError(t, batch.Flush())
+
+	assert.ElementsMatch(t, []int{12, 17}, payloadSizes(cwAPI.capturedPayloads))
+
+	for _, p := range cwAPI.capturedPayloads {
+		prefixes := map[byte]bool{}
+		for _, d := range p.MetricData {
+			prefixes[aws.StringValue(d.MetricName)[0]] = true
+		}
+
+		if len(p.MetricData) == 12 {
+			assert.Len(t, prefixes, 1)
+		}
+	}
+}
+
+func TestPreserveInputBatchesSplitsLargeInputs(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithPreserveInputBatches())
+
+	batch.Add("ns", metricData("a", 25)...)
+	batch.Add("ns", metricData("b", 10)...)
+
+	require.NoError(t, batch.Flush())
+
+	assert.ElementsMatch(t, []int{20, 15}, payloadSizes(cwAPI.capturedPayloads))
+}
+
+func TestWithoutPreservingInputBatches(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	batch.Add("ns", metricData("a", 12)...)
+	batch.Add("ns", metricData("b", 12)...)
+	batch.Add("ns", metricData("c", 5)...)
+
+	require.NoError(t, batch.Flush())
+
+	assert.ElementsMatch(t, []int{20, 9}, payloadSizes(cwAPI.capturedPayloads))
+}
+
+func TestFlushWithCancelledContextKeepsMetrics(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithPreserveOnCancelledContext())
+	batch.Add("myApp", metricData("metric", 25)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, batch.FlushCtx(ctx))
+	assert.Equal(t, context.Canceled, batch.FlushCompleteBatchesCtx(ctx))
+	assert.Empty(t, cwAPI.payloads())
+	assert.Equal(t, int64(25), batch.Stats().Pending)
+
+	require.NoError(t, batch.Flush())
+	assert.ElementsMatch(t, []int{20, 5}, payloadSizes(cwAPI.payloads()))
+}
+
+func TestFlushWithCancelledContextByDefault(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+	batch.Add("myApp", metricData("metric", 25)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, batch.FlushCtx(ctx))
+
+	n, err := batch.FlushCtxN(ctx)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, context.Canceled, err)
+
+	assert.Equal(t, context.Canceled, batch.FlushCompleteBatchesCtx(ctx))
+	assert.Equal(t, context.Canceled, batch.FlushNamespace(ctx, "myApp"))
+	assert.Equal(t, context.Canceled, batch.FlushTo(ctx, func(context.Context, *cw.PutMetricDataInput) error {
+		return nil
+	}))
+	assert.Equal(t, context.DeadlineExceeded, batch.FlushWithTimeout(0))
+
+	assert.Empty(t, cwAPI.payloads())
+	assert.Equal(t, int64(25), batch.Stats().Pending, "the metrics that aren't sent are kept")
+	assert.Equal(t, 25, batch.Len())
+}
+
+func TestFlushOnCancelledContext(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithFlushOnCancelledContext(time.Second))
+	batch.Add("myApp", metricData("metric", 25)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.NoError(t, batch.FlushCtx(ctx))
+	assert.ElementsMatch(t, []int{20, 5}, payloadSizes(cwAPI.payloads()))
+	assert.Equal(t, int64(0), batch.Stats().Pending)
+}
+
+func TestFlushNamespace(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	batch.Add("tenant1", metricData("metric", 25)...)
+	batch.Add("tenant2", metricData("metric", 3)...)
+
+	require.NoError(t, batch.FlushNamespace(context.Background(), "tenant1"))
+
+	payloads := cwAPI.payloads()
+	assert.ElementsMatch(t, []int{20, 5}, payloadSizes(payloads))
+
+	for _, p := range payloads {
+		assert.Equal(t, "tenant1", aws.StringValue(p.Namespace))
+	}
+
+	assert.Equal(t, map[string]int{"tenant2": 3}, batch.LenByNamespace())
+	assert.Equal(t, int64(3), batch.Stats().Pending)
+
+	require.NoError(t, batch.FlushNamespace(context.Background(), "tenant1"))
+	require.NoError(t, batch.FlushNamespace(context.Background(), "unknown"))
+	assert.Len(t, cwAPI.payloads(), 2)
+}
+
+func TestFlushCtxN(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailNamespace: "broken"}
+	batch := cwatsch.New(client)
+
+	batch.Add("myApp", metricData("metric", 25)...)
+
+	n, err := batch.FlushCtxN(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 25, n)
+
+	n, err = batch.FlushCtxN(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	batch.Add("broken", metricData("metric", 3)...)
+
+	n, err = batch.FlushCtxN(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestAddHighRes(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	datum := cwatsch.Datum("latency").Value(1).Build()
+	batch.AddHighRes("myApp", datum)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Equal(t, int64(1), aws.Int64Value(cwAPI.capturedPayloads[0].MetricData[0].StorageResolution))
+	assert.Nil(t, datum.StorageResolution, "caller's datum is not modified")
+}
+
+func TestOnFlushError(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailNamespace: "broken"}
+
+	type failure struct {
+		ns    string
+		names []string
+		err   error
+	}
+
+	var failures []failure
+
+	batch := cwatsch.New(client, cwatsch.WithOnFlushError(func(ns string, batch []*cw.MetricDatum, err error) {
+		names := []string{}
+		for _, d := range batch {
+			names = append(names, aws.StringValue(d.MetricName))
+		}
+
+		failures = append(failures, failure{ns, names, err})
+	}))
+
+	batch.Add("broken", metricData("metric", 2)...)
+	batch.Add("fine", metricData("metric", 1)...)
+
+	err := batch.Flush()
+	require.Error(t, err)
+
+	require.Len(t, failures, 1)
+	assert.Equal(t, "broken", failures[0].ns)
+	assert.Equal(t, []string{"metric0", "metric1"}, failures[0].names)
+	assert.Equal(t, err, failures[0].err)
+}
+
+type hangingMock struct {
+	cwMock
+}
+
+func (mock *hangingMock) PutMetricDataWithContext(
+	ctx aws.Context, _ *cw.PutMetricDataInput, _ ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	<-ctx.Done()
+	return nil, awserr.New(request.CanceledErrorCode, "request context canceled", ctx.Err())
+}
+
+func TestFlushWithTimeout(t *testing.T) {
+	batch := cwatsch.New(&hangingMock{})
+	batch.Add("myApp", metricData("metric", 25)...)
+
+	start := time.Now()
+
+	assert.Equal(t, context.DeadlineExceeded, batch.FlushWithTimeout(10*time.Millisecond))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	assert.Equal(t, 25, batch.Len(), "unsent metrics stay buffered")
+
+	cwAPI := cwMock{}
+	batch = cwatsch.New(&cwAPI)
+	batch.Add("myApp", metricData("metric", 3)...)
+
+	require.NoError(t, batch.FlushWithTimeout(time.Second))
+	assert.Len(t, cwAPI.payloads(), 1)
+}
+
+type slowMock struct {
+	cwMock
+	delay time.Duration
+}
+
+func (mock *slowMock) PutMetricDataWithContext(
+	ctx aws.Context, input *cw.PutMetricDataInput, opts ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	time.Sleep(mock.delay)
+	return mock.cwMock.PutMetricDataWithContext(ctx, input, opts...)
+}
+
+func TestOnFlushLatency(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		sizes     []int
+	)
+
+	batch := cwatsch.New(&slowMock{delay: 5 * time.Millisecond}, cwatsch.WithOnFlushLatency(func(d time.Duration, batchSize int) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		latencies = append(latencies, d)
+		sizes = append(sizes, batchSize)
+	}))
+
+	batch.Add("myApp", metricData("metric", 25)...)
+	require.NoError(t, batch.Flush())
+
+	assert.ElementsMatch(t, []int{20, 5}, sizes)
+
+	for _, d := range latencies {
+		assert.GreaterOrEqual(t, int64(d), int64(5*time.Millisecond))
+	}
+}
+
+func TestMaxBatchSize(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxBatchSize(3))
+
+	batch.Add("myApp", metricData("metric", 7)...)
+
+	require.NoError(t, batch.FlushCompleteBatches())
+	assert.ElementsMatch(t, []int{3, 3}, payloadSizes(cwAPI.payloads()))
+	assert.Equal(t, 1, batch.Len())
+
+	require.NoError(t, batch.Flush())
+	assert.ElementsMatch(t, []int{3, 3, 1}, payloadSizes(cwAPI.payloads()))
+
+	cwAPI = cwMock{}
+	batch = cwatsch.New(&cwAPI, cwatsch.WithMaxBatchSize(100))
+	batch.Add("myApp", metricData("metric", 25)...)
+
+	require.NoError(t, batch.Flush())
+	assert.ElementsMatch(t, []int{20, 5}, payloadSizes(cwAPI.payloads()), "the size is clamped to 20")
+}
+
+func TestFlushEvery(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	stop := batch.FlushEvery(5*time.Millisecond, nil)
+
+	batch.Add("myApp", metricData("metric", 3)...)
+	require.Eventually(t, func() bool { return len(cwAPI.payloads()) == 1 }, time.Second, time.Millisecond)
+
+	batch.Add("myApp", metricData("metric", 2)...)
+	stop()
+	stop()
+
+	assert.Equal(t, 0, batch.Len(), "stop flushes the remaining metrics")
+	assert.Equal(t, int64(5), batch.Stats().MetricsSent)
+}
+
+type concurrencyMock struct {
+	slowMock
+	inFlight    int64
+	maxInFlight int64
+}
+
+func (mock *concurrencyMock) PutMetricDataWithContext(
+	ctx aws.Context, input *cw.PutMetricDataInput, opts ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	n := atomic.AddInt64(&mock.inFlight, 1)
+	defer atomic.AddInt64(&mock.inFlight, -1)
+
+	for {
+		max := atomic.LoadInt64(&mock.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt64(&mock.maxInFlight, max, n) {
+			break
+		}
+	}
+
+	return mock.slowMock.PutMetricDataWithContext(ctx, input, opts...)
+}
+
+func TestMaxConcurrentFlushes(t *testing.T) {
+	cwAPI := concurrencyMock{slowMock: slowMock{delay: time.Millisecond}}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxConcurrentFlushes(2))
+
+	batch.Add("myApp", metricData("metric", 100*20)...)
+	require.NoError(t, batch.Flush())
+
+	assert.Len(t, cwAPI.payloads(), 100)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&cwAPI.maxInFlight))
+
+	cwAPI = concurrencyMock{slowMock: slowMock{delay: time.Millisecond}}
+	batch = cwatsch.New(&cwAPI, cwatsch.WithMaxConcurrentFlushes(0))
+
+	batch.Add("myApp", metricData("metric", 100*20)...)
+	require.NoError(t, batch.Flush())
+
+	assert.Greater(t, atomic.LoadInt64(&cwAPI.maxInFlight), int64(2), "n <= 0 doesn't limit the flushes")
+}
+
+func TestSlowFlushSendsEveryNamespace(t *testing.T) {
+	cwAPI := slowMock{delay: 10 * time.Millisecond}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithMaxConcurrentFlushes(1))
+
+	namespaces := []string{}
+
+	for i := 0; i < 10; i++ {
+		ns := fmt.Sprintf("ns%d", i)
+		namespaces = append(namespaces, ns)
+		batch.Add(ns, metricData("metric", 10*20)...)
+	}
+
+	assert.Error(t, batch.FlushWithTimeout(300*time.Millisecond))
+
+	payloads := cwAPI.payloads()
+	require.Less(t, len(payloads), 100, "the flush is cut short by the timeout")
+
+	sent := map[string]bool{}
+	for _, p := range payloads {
+		sent[*p.Namespace] = true
+	}
+
+	for _, ns := range namespaces {
+		assert.True(t, sent[ns], "namespace %s isn't starved by the others", ns)
+	}
+}
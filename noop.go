@@ -0,0 +1,51 @@
+package cwatsch
+
+import (
+	"context"
+
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// MetricBatcher is the part of Batch the code producing metrics usually
+// depends on. Depending on it instead of *Batch makes it easy to substitute
+// the batch, e.g. with NewNoop where CloudWatch isn't available. Its methods
+// don't chain: AddCtx and Receive add the datums and the inputs the same way
+// Add and AddInputs do, which keep returning *Batch.
+type MetricBatcher interface {
+	AddCtx(ctx context.Context, namespace string, data ...*cw.MetricDatum) error
+	Receive(ctx context.Context, input *cw.PutMetricDataInput) error
+	Flush() error
+	FlushCompleteBatches() error
+}
+
+var (
+	_ MetricBatcher = (*Batch)(nil)
+	_ MetricBatcher = Noop{}
+)
+
+// Noop is a MetricBatcher discarding all the metrics added to it, see NewNoop.
+type Noop struct{}
+
+// NewNoop creates a MetricBatcher discarding all the metrics added to it, meant
+// for environments without CloudWatch like local development or CI. Nothing is
+// buffered, nothing is sent and flushing always succeeds, so that the call
+// sites don't need to check whether metrics are enabled.
+func NewNoop() Noop {
+	return Noop{}
+}
+
+func (Noop) AddCtx(context.Context, string, ...*cw.MetricDatum) error {
+	return nil
+}
+
+func (Noop) Receive(context.Context, *cw.PutMetricDataInput) error {
+	return nil
+}
+
+func (Noop) Flush() error {
+	return nil
+}
+
+func (Noop) FlushCompleteBatches() error {
+	return nil
+}
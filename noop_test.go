@@ -0,0 +1,46 @@
+package cwatsch_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoop(t *testing.T) {
+	var batcher cwatsch.MetricBatcher = cwatsch.NewNoop()
+
+	require.NoError(t, batcher.AddCtx(context.Background(), "myApp", metricData("metric", 25)...))
+	require.NoError(t, batcher.Receive(context.Background(), &cw.PutMetricDataInput{
+		Namespace:  aws.String("myApp"),
+		MetricData: metricData("metric", 3),
+	}))
+
+	require.NoError(t, batcher.FlushCompleteBatches())
+	require.NoError(t, batcher.Flush())
+}
+
+func TestBatchIsMetricBatcher(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	var batcher cwatsch.MetricBatcher = batch
+
+	require.NoError(t, batcher.AddCtx(context.Background(), "myApp", metricData("metric", 25)...))
+	require.NoError(t, batcher.FlushCompleteBatches())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	require.NoError(t, batcher.Receive(context.Background(), &cw.PutMetricDataInput{
+		Namespace:  aws.String("myApp"),
+		MetricData: metricData("metric", 3),
+	}))
+	require.NoError(t, batcher.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 2)
+	assert.Len(t, cwAPI.capturedPayloads[1].MetricData, 8)
+
+	assert.Same(t, batch, batch.Add("myApp", metricData("metric", 1)...), "Add keeps chaining on *Batch")
+}
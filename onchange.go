@@ -0,0 +1,70 @@
+package cwatsch
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithEmitOnChange suppresses the datums of the namespace whose value equals the
+// last value queued for the same metric (name and dimensions), so that only
+// changes are sent. An unchanged value is still sent once keepalive has passed
+// since it was last queued, which keeps the metric from looking stale; a
+// keepalive of zero disables that. This is a major cost lever for config- or
+// state-style gauges that rarely change. Only datums carrying a single Value
+// are considered, StatisticValues and Values are always sent.
+func WithEmitOnChange(namespace string, keepalive time.Duration) Option {
+	return func(b *Batch) {
+		if b.emitOnChange == nil {
+			b.emitOnChange = map[string]time.Duration{}
+			b.lastValues = map[string]map[string]lastValue{}
+		}
+
+		b.emitOnChange[namespace] = keepalive
+		b.lastValues[namespace] = map[string]lastValue{}
+	}
+}
+
+type lastValue struct {
+	value float64
+	at    time.Time
+}
+
+// unchanged reports whether the datum should be suppressed since its value
+// hasn't changed. Must be called with the lock held.
+func (b *Batch) unchanged(ns string, d *cw.MetricDatum) bool {
+	keepalive, ok := b.emitOnChange[ns]
+	if !ok || d == nil || d.Value == nil || d.StatisticValues != nil || len(d.Values) > 0 {
+		return false
+	}
+
+	now := time.Now()
+	key := seriesKey(d)
+	last, seen := b.lastValues[ns][key]
+
+	if seen && last.value == *d.Value && (keepalive <= 0 || now.Sub(last.at) < keepalive) {
+		return true
+	}
+
+	b.lastValues[ns][key] = lastValue{value: *d.Value, at: now}
+
+	return false
+}
+
+// seriesKey identifies the metric the datum belongs to by its name and
+// dimensions. The order of the dimensions doesn't matter.
+func seriesKey(d *cw.MetricDatum) string {
+	parts := make([]string, 0, len(d.Dimensions))
+	for _, dim := range d.Dimensions {
+		if dim != nil {
+			parts = append(parts, aws.StringValue(dim.Name)+"="+aws.StringValue(dim.Value))
+		}
+	}
+
+	sort.Strings(parts)
+
+	return aws.StringValue(d.MetricName) + "\x00" + strings.Join(parts, "\x00")
+}
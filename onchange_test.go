@@ -0,0 +1,59 @@
+package cwatsch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sentValues(payloads []*cw.PutMetricDataInput) []float64 {
+	values := []float64{}
+	for _, p := range payloads {
+		for _, d := range p.MetricData {
+			values = append(values, aws.Float64Value(d.Value))
+		}
+	}
+
+	return values
+}
+
+func TestEmitOnChange(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithEmitOnChange("config", 0))
+
+	for _, v := range []float64{1, 1, 2, 2, 2, 1} {
+		batch.Add("config", cwatsch.Datum("replicas").Value(v).Build())
+		batch.Add("other", cwatsch.Datum("replicas").Value(v).Build())
+	}
+
+	batch.Add("config", cwatsch.Datum("replicas").Value(1).Dim("zone", "a").Build())
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 2)
+
+	sortByNS(cwAPI.capturedPayloads)
+
+	assert.Equal(t, []float64{1, 2, 1, 1}, sentValues(cwAPI.capturedPayloads[:1]))
+	assert.Equal(t, []float64{1, 1, 2, 2, 2, 1}, sentValues(cwAPI.capturedPayloads[1:]))
+}
+
+func TestEmitOnChangeKeepalive(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithEmitOnChange("config", 20*time.Millisecond))
+
+	batch.Add("config", cwatsch.Datum("replicas").Value(3).Build())
+	batch.Add("config", cwatsch.Datum("replicas").Value(3).Build())
+
+	time.Sleep(30 * time.Millisecond)
+
+	batch.Add("config", cwatsch.Datum("replicas").Value(3).Build())
+
+	require.NoError(t, batch.Flush())
+
+	assert.Equal(t, []float64{3, 3}, sentValues(cwAPI.capturedPayloads))
+}
@@ -0,0 +1,57 @@
+package cwatsch
+
+import "sort"
+
+// WithFlushOrder makes the flushes send the namespaces in a stable order
+// instead of a random one. The given namespaces go first, in the given order,
+// each of them drained before the next one. The remaining namespaces follow,
+// sorted by name and interleaved as usual.
+//
+// The order decides which metrics get out when a flush can't send everything,
+// e.g. because its deadline hits on shutdown. Combined with
+// WithMaxConcurrentFlushes it decides the order of the requests as well.
+func WithFlushOrder(priority ...string) Option {
+	return func(b *Batch) {
+		b.orderedFlush = true
+		b.flushPriority = append([]string(nil), priority...)
+	}
+}
+
+// flushGroups returns the namespaces of the queues in the order they are to be
+// flushed. Every group is drained before the next one is started.
+func (b *Batch) flushGroups(metricQs map[string]*queue) [][]string {
+	if !b.orderedFlush {
+		return [][]string{namespacesOf(metricQs)}
+	}
+
+	groups := make([][]string, 0, len(b.flushPriority)+1)
+	prioritized := make(map[string]bool, len(b.flushPriority))
+
+	for _, ns := range b.flushPriority {
+		if _, ok := metricQs[ns]; ok && !prioritized[ns] {
+			groups = append(groups, []string{ns})
+		}
+
+		prioritized[ns] = true
+	}
+
+	rest := make([]string, 0, len(metricQs))
+	for ns := range metricQs {
+		if !prioritized[ns] {
+			rest = append(rest, ns)
+		}
+	}
+
+	sort.Strings(rest)
+
+	return append(groups, rest)
+}
+
+func namespacesOf(metricQs map[string]*queue) []string {
+	namespaces := make([]string, 0, len(metricQs))
+	for ns := range metricQs {
+		namespaces = append(namespaces, ns)
+	}
+
+	return namespaces
+}
@@ -0,0 +1,36 @@
+package cwatsch
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithPercentiles declares metrics of the namespace as percentile-eligible, i.e.
+// metrics whose percentiles (p99 etc.) are going to be queried. CloudWatch can
+// only compute percentiles from raw observations, so the batch makes sure the
+// distribution of such metrics is never destroyed:
+//
+//   - a datum carrying a single Value is sent as Values/Counts with the raw
+//     observation preserved,
+//   - a datum carrying StatisticValues is rejected (and reported via
+//     WithOnError), since a statistic set has no percentiles,
+//   - the metrics are never summarized into statistic sets by the batch.
+//
+// Preserving raw observations has a cost: every distinct value takes an entry
+// of the Values array instead of being folded into a statistic set, so more
+// data (and potentially more requests) is sent for the same traffic.
+func WithPercentiles(namespace string, names ...string) Option {
+	return func(b *Batch) {
+		if b.percentiles == nil {
+			b.percentiles = map[string]bool{}
+		}
+
+		for _, name := range names {
+			b.percentiles[namespace+"\x00"+name] = true
+		}
+	}
+}
+
+func (b *Batch) isPercentile(ns string, d *cw.MetricDatum) bool {
+	return b.percentiles[ns+"\x00"+aws.StringValue(d.MetricName)]
+}
@@ -0,0 +1,60 @@
+package cwatsch_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentileMetricsKeepRawObservations(t *testing.T) {
+	cwAPI := cwMock{}
+
+	var errs []error
+
+	batch := cwatsch.New(&cwAPI,
+		cwatsch.WithPercentiles("myApp", "latency"),
+		cwatsch.WithOnError(func(err error) { errs = append(errs, err) }),
+	)
+
+	summarized := &cw.MetricDatum{
+		MetricName: aws.String("latency"),
+		StatisticValues: &cw.StatisticSet{
+			SampleCount: aws.Float64(2),
+			Sum:         aws.Float64(3),
+			Minimum:     aws.Float64(1),
+			Maximum:     aws.Float64(2),
+		},
+	}
+
+	batch.Add("myApp",
+		&cw.MetricDatum{MetricName: aws.String("latency"), Value: aws.Float64(12)},
+		summarized,
+		&cw.MetricDatum{MetricName: aws.String("calls"), Value: aws.Float64(1)},
+	)
+	batch.Add("other", &cw.MetricDatum{MetricName: aws.String("latency"), Value: aws.Float64(5)})
+
+	require.NoError(t, batch.Flush())
+
+	sortByNS(cwAPI.capturedPayloads)
+	require.Len(t, cwAPI.capturedPayloads, 2)
+
+	assert.Equal(t, []*cw.MetricDatum{
+		{MetricName: aws.String("latency"), Values: aws.Float64Slice([]float64{12}), Counts: aws.Float64Slice([]float64{1})},
+		{MetricName: aws.String("calls"), Value: aws.Float64(1)},
+	}, cwAPI.capturedPayloads[0].MetricData)
+	assert.Equal(t, []*cw.MetricDatum{
+		{MetricName: aws.String("latency"), Value: aws.Float64(5)},
+	}, cwAPI.capturedPayloads[1].MetricData)
+
+	require.Len(t, errs, 1)
+
+	var datumErr *cwatsch.DatumError
+
+	require.IsType(t, datumErr, errs[0])
+	assert.Equal(t, summarized, errs[0].(*cwatsch.DatumError).Datum)
+	assert.Equal(t, int64(1), batch.Stats().Dropped)
+}
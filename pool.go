@@ -0,0 +1,180 @@
+package cwatsch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// PoolStrategy defines how ClientPool picks the client for a request.
+type PoolStrategy int
+
+const (
+	// RoundRobin picks the clients in turn, ignoring their weights.
+	RoundRobin PoolStrategy = iota
+	// Weighted picks the clients in proportion to their weights. The picks
+	// are spread evenly, i.e. a client of weight 3 isn't picked three times
+	// in a row.
+	Weighted
+	// LeastRecentlyUsed picks the client with the fewest requests in flight,
+	// and among those the one that has been idle for the longest time. Slow
+	// or stalled clients receive less traffic this way.
+	LeastRecentlyUsed
+)
+
+// PoolMember is a client of a ClientPool.
+type PoolMember struct {
+	Client cloudwatchiface.CloudWatchAPI
+	// Weight is only used by the Weighted strategy. Members with a weight
+	// below 1 get a weight of 1.
+	Weight int
+}
+
+// ClientPool distributes PutMetricData requests across several CloudWatch
+// clients, e.g. clients using different credentials or regions, to stay below
+// the per-client throttling limits at very high volume. The pool is passed to
+// New in place of a single client.
+//
+// Errors returned by the pool are *ClientError values, telling which client
+// has failed.
+//
+// Only PutMetricData and PutMetricDataWithContext are implemented, calling any
+// other method of the pool panics.
+type ClientPool struct {
+	cloudwatchiface.CloudWatchAPI
+
+	strategy PoolStrategy
+
+	mu      sync.Mutex
+	members []poolMember
+	next    int
+	seq     int64
+}
+
+type poolMember struct {
+	client   cloudwatchiface.CloudWatchAPI
+	weight   int
+	current  int
+	inFlight int
+	lastDone int64
+	errors   int64
+}
+
+// NewClientPool creates a pool of the members using the strategy.
+func NewClientPool(strategy PoolStrategy, members ...PoolMember) *ClientPool {
+	p := &ClientPool{strategy: strategy, members: make([]poolMember, len(members))}
+
+	for i, m := range members {
+		p.members[i] = poolMember{client: m.Client, weight: m.Weight}
+		if p.members[i].weight < 1 {
+			p.members[i].weight = 1
+		}
+	}
+
+	return p
+}
+
+// ClientError is the error of a request sent by a client of a ClientPool.
+type ClientError struct {
+	// Client is the index of the failed client among the pool's members.
+	Client int
+	Err    error
+}
+
+func (e *ClientError) Error() string {
+	return fmt.Sprintf("cwatsch: pool client %d: %v", e.Client, e.Err)
+}
+
+func (e *ClientError) Unwrap() error {
+	return e.Err
+}
+
+func (p *ClientPool) PutMetricData(input *cw.PutMetricDataInput) (*cw.PutMetricDataOutput, error) {
+	return p.PutMetricDataWithContext(aws.BackgroundContext(), input)
+}
+
+func (p *ClientPool) PutMetricDataWithContext(
+	ctx aws.Context, input *cw.PutMetricDataInput, opts ...request.Option,
+) (*cw.PutMetricDataOutput, error) {
+	if len(p.members) == 0 {
+		return nil, errors.New("cwatsch: client pool is empty")
+	}
+
+	i := p.pick()
+
+	out, err := p.members[i].client.PutMetricDataWithContext(ctx, input, opts...)
+
+	p.mu.Lock()
+	p.seq++
+	p.members[i].inFlight--
+	p.members[i].lastDone = p.seq
+
+	if err != nil {
+		p.members[i].errors++
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return out, &ClientError{Client: i, Err: err}
+	}
+
+	return out, nil
+}
+
+// Errors returns the number of failed requests per client, in the order of
+// the pool's members.
+func (p *ClientPool) Errors() []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	errs := make([]int64, len(p.members))
+	for i, m := range p.members {
+		errs[i] = m.errors
+	}
+
+	return errs
+}
+
+func (p *ClientPool) pick() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var i int
+
+	switch p.strategy {
+	case Weighted:
+		// smooth weighted round-robin
+		total := 0
+
+		for j := range p.members {
+			m := &p.members[j]
+			m.current += m.weight
+			total += m.weight
+
+			if m.current > p.members[i].current {
+				i = j
+			}
+		}
+
+		p.members[i].current -= total
+	case LeastRecentlyUsed:
+		for j, m := range p.members {
+			best := p.members[i]
+			if m.inFlight < best.inFlight || (m.inFlight == best.inFlight && m.lastDone < best.lastDone) {
+				i = j
+			}
+		}
+	default:
+		i = p.next
+		p.next = (p.next + 1) % len(p.members)
+	}
+
+	p.members[i].inFlight++
+
+	return i
+}
@@ -0,0 +1,82 @@
+package cwatsch_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/molecule-man/cwatsch/cwatschtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sendToPool(t *testing.T, pool *cwatsch.ClientPool, n int) {
+	for i := 0; i < n; i++ {
+		_, err := pool.PutMetricData(&cw.PutMetricDataInput{Namespace: aws.String("myApp")})
+		require.NoError(t, err)
+	}
+}
+
+func TestClientPoolRoundRobin(t *testing.T) {
+	clients := []*cwMock{{}, {}, {}}
+	pool := cwatsch.NewClientPool(cwatsch.RoundRobin,
+		cwatsch.PoolMember{Client: clients[0], Weight: 5},
+		cwatsch.PoolMember{Client: clients[1]},
+		cwatsch.PoolMember{Client: clients[2]},
+	)
+
+	sendToPool(t, pool, 9)
+
+	for _, c := range clients {
+		assert.Len(t, c.payloads(), 3)
+	}
+}
+
+func TestClientPoolWeighted(t *testing.T) {
+	primary, secondary := &cwMock{}, &cwMock{}
+	pool := cwatsch.NewClientPool(cwatsch.Weighted,
+		cwatsch.PoolMember{Client: primary, Weight: 3},
+		cwatsch.PoolMember{Client: secondary, Weight: 1},
+	)
+
+	sendToPool(t, pool, 8)
+
+	assert.Len(t, primary.payloads(), 6)
+	assert.Len(t, secondary.payloads(), 2)
+}
+
+func TestClientPoolLeastRecentlyUsed(t *testing.T) {
+	clients := []*cwMock{{}, {}}
+	pool := cwatsch.NewClientPool(cwatsch.LeastRecentlyUsed,
+		cwatsch.PoolMember{Client: clients[0]},
+		cwatsch.PoolMember{Client: clients[1]},
+	)
+
+	sendToPool(t, pool, 4)
+
+	assert.Len(t, clients[0].payloads(), 2)
+	assert.Len(t, clients[1].payloads(), 2)
+}
+
+func TestClientPoolAttributesErrors(t *testing.T) {
+	healthy := &cwMock{}
+	failing := &cwatschtest.FlakyClient{FailFirst: 100}
+	pool := cwatsch.NewClientPool(cwatsch.RoundRobin,
+		cwatsch.PoolMember{Client: healthy},
+		cwatsch.PoolMember{Client: failing},
+	)
+
+	sendToPool(t, pool, 1)
+
+	_, err := pool.PutMetricData(&cw.PutMetricDataInput{Namespace: aws.String("myApp")})
+	require.Error(t, err)
+
+	var clientErr *cwatsch.ClientError
+
+	require.True(t, errors.As(err, &clientErr))
+	assert.Equal(t, 1, clientErr.Client)
+	assert.Equal(t, []int64{0, 1}, pool.Errors())
+	assert.Len(t, healthy.payloads(), 1)
+}
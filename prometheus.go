@@ -0,0 +1,130 @@
+package cwatsch
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// PrometheusHandler returns a handler exposing the currently buffered metrics in
+// the Prometheus text exposition format, so Prometheus can scrape the same
+// numbers that are about to be sent to CloudWatch.
+//
+// The name of a series is the namespace and the metric name joined by "_",
+// with the characters Prometheus doesn't allow replaced by "_". Dimensions are
+// exposed as labels. If a series is buffered several times, the most recently
+// added value is exposed. Datums carrying StatisticValues or Values are exposed
+// as the *_sum and *_count series (and *_min and *_max for StatisticValues).
+func (b *Batch) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		b.writePrometheus(w)
+	})
+}
+
+func (b *Batch) writePrometheus(w io.Writer) {
+	series := map[string]float64{}
+
+	b.Lock()
+	for ns, q := range b.metricQs {
+		q.each(func(d *cw.MetricDatum) {
+			if d == nil {
+				return
+			}
+
+			name := promName(ns + "_" + aws.StringValue(d.MetricName))
+			labels := promLabels(d.Dimensions)
+
+			for suffix, v := range promValues(d) {
+				series[name+suffix+labels] = v
+			}
+		})
+	}
+	b.Unlock()
+
+	lines := make([]string, 0, len(series))
+	for s, v := range series {
+		lines = append(lines, s+" "+strconv.FormatFloat(v, 'g', -1, 64))
+	}
+
+	sort.Strings(lines)
+
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+}
+
+func promValues(d *cw.MetricDatum) map[string]float64 {
+	switch {
+	case d.StatisticValues != nil:
+		return map[string]float64{
+			"_sum":   aws.Float64Value(d.StatisticValues.Sum),
+			"_count": aws.Float64Value(d.StatisticValues.SampleCount),
+			"_min":   aws.Float64Value(d.StatisticValues.Minimum),
+			"_max":   aws.Float64Value(d.StatisticValues.Maximum),
+		}
+	case len(d.Values) > 0:
+		var sum, count float64
+
+		for i, v := range d.Values {
+			c := 1.0
+			if i < len(d.Counts) {
+				c = aws.Float64Value(d.Counts[i])
+			}
+
+			sum += aws.Float64Value(v) * c
+			count += c
+		}
+
+		return map[string]float64{"_sum": sum, "_count": count}
+	case d.Value != nil:
+		return map[string]float64{"": aws.Float64Value(d.Value)}
+	}
+
+	return nil
+}
+
+func promName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+
+		return '_'
+	}, s)
+
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
+
+var promLabelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func promLabels(dims []*cw.Dimension) string {
+	if len(dims) == 0 {
+		return ""
+	}
+
+	labels := make([]string, 0, len(dims))
+
+	for _, d := range dims {
+		if d == nil {
+			continue
+		}
+
+		name := strings.Replace(promName(aws.StringValue(d.Name)), ":", "_", -1)
+		labels = append(labels, name+`="`+promLabelValueEscaper.Replace(aws.StringValue(d.Value))+`"`)
+	}
+
+	sort.Strings(labels)
+
+	return "{" + strings.Join(labels, ",") + "}"
+}
@@ -0,0 +1,56 @@
+package cwatsch_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	batch := cwatsch.New(&cwMock{})
+
+	batch.Add("myApp",
+		cwatsch.Datum("latency").Value(12).Dim("endpoint", "/users").Dim("method", "GET").Build(),
+		cwatsch.Datum("latency").Value(15).Dim("endpoint", "/users").Dim("method", "GET").Build(),
+		cwatsch.Datum("queue.depth").Value(3).Build(),
+		&cw.MetricDatum{
+			MetricName: aws.String("size"),
+			StatisticValues: &cw.StatisticSet{
+				SampleCount: aws.Float64(2),
+				Sum:         aws.Float64(30),
+				Minimum:     aws.Float64(10),
+				Maximum:     aws.Float64(20),
+			},
+		},
+		&cw.MetricDatum{
+			MetricName: aws.String("duration"),
+			Values:     aws.Float64Slice([]float64{1, 2}),
+			Counts:     aws.Float64Slice([]float64{3, 1}),
+		},
+	)
+	batch.Add("other", cwatsch.Datum("calls").Value(1).Dim("path", `a"b`).Build())
+
+	rec := httptest.NewRecorder()
+	batch.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, `myApp_duration_count 4
+myApp_duration_sum 5
+myApp_latency{endpoint="/users",method="GET"} 15
+myApp_queue_depth 3
+myApp_size_count 2
+myApp_size_max 20
+myApp_size_min 10
+myApp_size_sum 30
+other_calls{path="a\"b"} 1
+`, string(body))
+
+	assert.Equal(t, int64(6), batch.Stats().Pending, "metrics stay buffered")
+}
@@ -0,0 +1,55 @@
+package cwatsch
+
+import "context"
+
+// WithContextPropagation makes the requests carry the values of the context the
+// metrics have been added with, e.g. the trace span of the code that produced
+// them, so that the flush shows up in the trace. The context passed to AddCtx
+// and PutMetricDataWithContext is remembered per namespace and the most recent
+// one provides the values of all the requests of the namespace, the
+// cancellation and deadline are still the ones of the flush. Add, AddInputs and
+// PutMetricData don't replace the remembered context, and it's forgotten once
+// the namespace has been flushed. EventCtx and AddHistoricalCtx send the
+// metrics with their context anyway.
+func WithContextPropagation() Option {
+	return func(b *Batch) {
+		b.propagateCtx = true
+	}
+}
+
+// remember records ctx as the context the metrics of the namespace have been
+// added with. Must be called with the lock held.
+func (b *Batch) remember(ns string, ctx context.Context) {
+	if !b.propagateCtx || ctx == nil || ctx == context.Background() {
+		return
+	}
+
+	if q, ok := b.metricQs[ns]; ok && q.count > 0 {
+		q.ctx = ctx
+	}
+}
+
+// requestContext returns the context to send the metrics taken from the queue
+// with.
+func requestContext(ctx context.Context, q *queue) context.Context {
+	if q == nil || q.ctx == nil {
+		return ctx
+	}
+
+	return valuesContext{Context: ctx, values: q.ctx}
+}
+
+// valuesContext is a context with the cancellation and deadline of the flush
+// and the values of the context the metrics have been added with.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	if v := c.values.Value(key); v != nil {
+		return v
+	}
+
+	return c.Context.Value(key)
+}
@@ -0,0 +1,68 @@
+package cwatsch_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type traceKey struct{}
+
+func TestContextPropagation(t *testing.T) {
+	batch := cwatsch.New(nil, cwatsch.WithContextPropagation())
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), traceKey{}, "trace-1"))
+	require.NoError(t, batch.AddCtx(ctx, "traced", cwatsch.Datum("m").Value(1).Build()))
+	cancel()
+
+	ctx = context.WithValue(context.Background(), traceKey{}, "trace-2")
+	_, err := batch.PutMetricDataWithContext(ctx, &cw.PutMetricDataInput{
+		Namespace:  aws.String("traced"),
+		MetricData: []*cw.MetricDatum{cwatsch.Datum("m").Value(2).Build()},
+	})
+	require.NoError(t, err)
+
+	batch.Add("traced", cwatsch.Datum("m").Value(3).Build())
+	batch.Add("untraced", cwatsch.Datum("m").Value(1).Build())
+
+	var (
+		mu     sync.Mutex
+		traces = map[string]interface{}{}
+	)
+
+	flushCtx := context.WithValue(context.Background(), traceKey{}, "flush")
+
+	send := func(ctx context.Context, input *cw.PutMetricDataInput) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		traces[aws.StringValue(input.Namespace)] = ctx.Value(traceKey{})
+
+		return ctx.Err()
+	}
+
+	require.NoError(t, batch.FlushTo(flushCtx, send), "the cancellation of the added context doesn't matter")
+	assert.Equal(t, map[string]interface{}{"traced": "trace-2", "untraced": "flush"}, traces)
+
+	batch.Add("traced", cwatsch.Datum("m").Value(1).Build())
+	require.NoError(t, batch.FlushTo(context.Background(), send))
+	assert.Nil(t, traces["traced"], "the context is forgotten after the flush")
+}
+
+func TestContextIsNotPropagatedByDefault(t *testing.T) {
+	batch := cwatsch.New(nil)
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "trace")
+	require.NoError(t, batch.AddCtx(ctx, "ns", cwatsch.Datum("m").Value(1).Build()))
+
+	require.NoError(t, batch.FlushTo(context.Background(), func(ctx context.Context, _ *cw.PutMetricDataInput) error {
+		assert.Nil(t, ctx.Value(traceKey{}))
+		return nil
+	}))
+}
@@ -0,0 +1,80 @@
+package cwatsch
+
+import (
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithRequeueOnError makes the batch keep the metrics of a failed request:
+// they are put back to the front of their namespace's queue, so that the next
+// flush sends them again, in the original order. To avoid an unbounded growth
+// of the buffer during a persistent failure, a datum is only attempted
+// maxAttempts times in total, then it's dropped.
+func WithRequeueOnError(maxAttempts int) Option {
+	return func(b *Batch) {
+		b.requeueAttempts = maxAttempts
+		b.attempts = map[*cw.MetricDatum]int{}
+	}
+}
+
+// requeueFailed puts the datums of a failed request back to the queue, except
+// for the ones that have run out of attempts. It returns the dropped datums.
+func (b *Batch) requeueFailed(ns string, batch []*cw.MetricDatum) []*cw.MetricDatum {
+	if b.requeueAttempts <= 1 {
+		return batch
+	}
+
+	keep := make([]*cw.MetricDatum, 0, len(batch))
+	var dropped []*cw.MetricDatum
+
+	b.attemptsMu.Lock()
+
+	for _, d := range batch {
+		b.attempts[d]++
+
+		if b.attempts[d] < b.requeueAttempts {
+			keep = append(keep, d)
+		} else {
+			delete(b.attempts, d)
+			dropped = append(dropped, d)
+		}
+	}
+
+	b.attemptsMu.Unlock()
+
+	if len(keep) > 0 {
+		b.requeue(ns, keep)
+	}
+
+	return dropped
+}
+
+// forgetAttempts discards the failed attempts of the datums that have been
+// sent or dropped.
+func (b *Batch) forgetAttempts(batch []*cw.MetricDatum) {
+	if b.requeueAttempts <= 1 {
+		return
+	}
+
+	b.attemptsMu.Lock()
+	defer b.attemptsMu.Unlock()
+
+	if len(b.attempts) == 0 {
+		return
+	}
+
+	for _, d := range batch {
+		delete(b.attempts, d)
+	}
+}
+
+// forgetAttempt discards the failed attempts of the datum that has been
+// dropped, e.g. by Reset.
+func (b *Batch) forgetAttempt(d *cw.MetricDatum) {
+	if b.requeueAttempts <= 1 {
+		return
+	}
+
+	b.attemptsMu.Lock()
+	delete(b.attempts, d)
+	b.attemptsMu.Unlock()
+}
@@ -0,0 +1,51 @@
+package cwatsch_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/molecule-man/cwatsch"
+	"github.com/molecule-man/cwatsch/cwatschtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequeueOnError(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailFirst: 1}
+	batch := cwatsch.New(client, cwatsch.WithRequeueOnError(3))
+
+	batch.Add("myApp", metricData("metric", 5)...)
+	require.Error(t, batch.Flush())
+
+	assert.Equal(t, int64(5), batch.Stats().Pending)
+	assert.Equal(t, int64(0), batch.Stats().Dropped)
+
+	batch.Add("myApp", metricData("later", 2)...)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, client.Inputs(), 1)
+
+	names := []string{}
+	for _, d := range client.Inputs()[0].MetricData {
+		names = append(names, aws.StringValue(d.MetricName))
+	}
+
+	assert.Equal(t, []string{"metric0", "metric1", "metric2", "metric3", "metric4", "later0", "later1"}, names)
+	assert.Equal(t, int64(0), batch.Stats().Pending)
+}
+
+func TestRequeueOnErrorIsLimited(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailFirst: 10}
+	batch := cwatsch.New(client, cwatsch.WithRequeueOnError(2))
+
+	batch.Add("myApp", metricData("metric", 5)...)
+	require.Error(t, batch.Flush())
+	assert.Equal(t, int64(5), batch.Stats().Pending)
+
+	require.Error(t, batch.Flush())
+	assert.Equal(t, int64(0), batch.Stats().Pending)
+	assert.Equal(t, int64(5), batch.Stats().Dropped)
+
+	require.NoError(t, batch.Flush())
+	assert.Equal(t, 2, client.Calls())
+}
@@ -0,0 +1,90 @@
+package cwatsch
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithRetry makes the batch retry the requests failing with a retryable error
+// (throttling or a server side failure), up to maxAttempts attempts in total.
+// The delay between the attempts starts at baseDelay and doubles with every
+// attempt, a random jitter of up to half the delay is subtracted to spread the
+// retries of concurrent requests. Retrying stops early if the context would
+// expire before the next attempt. Other errors (e.g. InvalidParameterValue)
+// fail the request right away.
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(b *Batch) {
+		b.retryAttempts = maxAttempts
+		b.retryDelay = baseDelay
+	}
+}
+
+var retryableCodes = map[string]bool{
+	"Throttling":                    true,
+	"ThrottlingException":           true,
+	"ThrottledException":            true,
+	"RequestLimitExceeded":          true,
+	"RequestThrottled":              true,
+	"TooManyRequestsException":      true,
+	"ServiceUnavailable":            true,
+	"InternalFailure":               true,
+	"InternalServiceError":          true,
+	"RequestTimeout":                true,
+	"RequestTimeoutException":       true,
+	"ProvisionedThroughputExceeded": true,
+}
+
+func isRetryable(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= 500 {
+		return true
+	}
+
+	var awsErr awserr.Error
+
+	return errors.As(err, &awsErr) && retryableCodes[awsErr.Code()]
+}
+
+// retrying wraps send with the retry policy of the batch.
+func (b *Batch) retrying(send SendFunc) SendFunc {
+	if b.retryAttempts <= 1 {
+		return send
+	}
+
+	return func(ctx context.Context, input *cw.PutMetricDataInput) error {
+		delay := b.retryDelay
+
+		for attempt := 1; ; attempt++ {
+			err := send(ctx, input)
+			if err == nil || attempt >= b.retryAttempts || !isRetryable(err) {
+				return err
+			}
+
+			wait := delay
+			if half := int64(delay / 2); half > 0 {
+				wait -= time.Duration(rand.Int63n(half))
+			}
+
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+				return err
+			}
+
+			b.logger.Info("cwatsch: retrying request",
+				"namespace", aws.StringValue(input.Namespace), "attempt", attempt+1, "delay", wait, "error", err)
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return err
+			}
+
+			delay *= 2
+		}
+	}
+}
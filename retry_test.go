@@ -0,0 +1,48 @@
+package cwatsch_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/molecule-man/cwatsch"
+	"github.com/molecule-man/cwatsch/cwatschtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryOnThrottling(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailFirst: 2, Err: cwatschtest.ThrottlingError}
+	batch := cwatsch.New(client, cwatsch.WithRetry(3, time.Millisecond))
+
+	batch.Add("myApp", metricData("metric", 5)...)
+	require.NoError(t, batch.Flush())
+
+	assert.Equal(t, 3, client.Calls())
+	require.Len(t, client.Inputs(), 1)
+	assert.Equal(t, int64(3), batch.Stats().APICalls)
+	assert.Equal(t, int64(5), batch.Stats().MetricsSent)
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailFirst: 5, Err: cwatschtest.ThrottlingError}
+	batch := cwatsch.New(client, cwatsch.WithRetry(3, time.Millisecond))
+
+	batch.Add("myApp", metricData("metric", 5)...)
+	assert.Equal(t, cwatschtest.ThrottlingError, batch.Flush())
+	assert.Equal(t, 3, client.Calls())
+	assert.Equal(t, int64(5), batch.Stats().Dropped)
+}
+
+func TestRetryFailsFastOnNonRetryableErrors(t *testing.T) {
+	invalid := awserr.NewRequestFailure(
+		awserr.New("InvalidParameterValue", "invalid value", nil), http.StatusBadRequest, "test",
+	)
+	client := &cwatschtest.FlakyClient{FailFirst: 1, Err: invalid}
+	batch := cwatsch.New(client, cwatsch.WithRetry(3, time.Millisecond))
+
+	batch.Add("myApp", metricData("metric", 5)...)
+	assert.Equal(t, invalid, batch.Flush())
+	assert.Equal(t, 1, client.Calls())
+}
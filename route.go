@@ -0,0 +1,28 @@
+package cwatsch
+
+import (
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// RouteNamespace makes the batch send the metrics of the namespace with api
+// instead of the client given to New, e.g. to publish them to another account
+// or region. Passing nil removes the route. It's safe to call while metrics
+// are being added and flushed, the route applies to the requests made
+// afterwards.
+func (b *Batch) RouteNamespace(namespace string, api cloudwatchiface.CloudWatchAPI) {
+	if api == nil {
+		b.routes.Delete(namespace)
+		return
+	}
+
+	b.routes.Store(namespace, api)
+}
+
+// client returns the client the metrics of the namespace are sent with.
+func (b *Batch) client(namespace string) cloudwatchiface.CloudWatchAPI {
+	if api, ok := b.routes.Load(namespace); ok {
+		return api.(cloudwatchiface.CloudWatchAPI)
+	}
+
+	return b.cwAPI
+}
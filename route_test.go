@@ -0,0 +1,35 @@
+package cwatsch_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteNamespace(t *testing.T) {
+	defaultAPI := cwMock{}
+	otherAccount := cwMock{}
+	batch := cwatsch.New(&defaultAPI)
+	batch.RouteNamespace("shared", &otherAccount)
+
+	batch.Add("myApp", metricData("metric", 2)...)
+	batch.Add("shared", metricData("metric", 3)...)
+
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, defaultAPI.capturedPayloads, 1)
+	assert.Equal(t, "myApp", aws.StringValue(defaultAPI.capturedPayloads[0].Namespace))
+	require.Len(t, otherAccount.capturedPayloads, 1)
+	assert.Equal(t, "shared", aws.StringValue(otherAccount.capturedPayloads[0].Namespace))
+
+	batch.RouteNamespace("shared", nil)
+	batch.Add("shared", metricData("metric", 1)...)
+
+	require.NoError(t, batch.Flush())
+
+	assert.Len(t, defaultAPI.capturedPayloads, 2)
+	assert.Len(t, otherAccount.capturedPayloads, 1)
+}
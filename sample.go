@@ -0,0 +1,102 @@
+package cwatsch
+
+import (
+	"math/rand"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// WithSampleRate makes the batch keep only the given fraction of the datums
+// added to it, chosen at random, e.g. 0.1 keeps one datum in ten. The kept
+// datums are weighted by 1/rate to stand in for the discarded ones: a Value
+// becomes a statistic set (or a value array with WithValueArrays and
+// WithPercentiles) of 1/rate samples of the value, the counts of value arrays
+// and the SampleCount and Sum of statistic sets are multiplied by 1/rate. The
+// averages and the sums then remain approximately correct, while far fewer
+// datums are queued and sent.
+//
+// Sampling is a trade-off: the sums and sample counts are estimates whose
+// error grows as the number of datums per period shrinks, so only metrics
+// reported many times per period should be sampled. Minimum, Maximum and the
+// tail percentiles miss the rare extreme values that happen to be discarded.
+//
+// Only the datums added with Add, AddInputs, AddCtx and PutMetricData are
+// sampled; counters, gauges, timings, events and the internal metrics aren't.
+// A rate outside of (0, 1) switches the sampling off.
+func WithSampleRate(rate float64) Option {
+	return func(b *Batch) {
+		if rate <= 0 || rate >= 1 {
+			rate = 0
+		}
+
+		b.sampleRate = rate
+	}
+}
+
+// sample returns the datums of the input kept by the sampling, weighted to
+// stand in for the discarded ones. The caller's datums are not modified.
+func (b *Batch) sample(input *cw.PutMetricDataInput) *cw.PutMetricDataInput {
+	if b.sampleRate == 0 {
+		return input
+	}
+
+	ns := aws.StringValue(input.Namespace)
+	weight := 1 / b.sampleRate
+
+	kept := make([]*cw.MetricDatum, 0, int(float64(len(input.MetricData))*b.sampleRate)+1)
+
+	for _, datum := range input.MetricData {
+		if datum == nil || rand.Float64() >= b.sampleRate {
+			continue
+		}
+
+		kept = append(kept, b.weighted(ns, datum, weight))
+	}
+
+	sampled := *input
+	sampled.MetricData = kept
+
+	return &sampled
+}
+
+func (b *Batch) weighted(ns string, datum *cw.MetricDatum, weight float64) *cw.MetricDatum {
+	d := *datum
+
+	switch {
+	case d.StatisticValues != nil:
+		s := *d.StatisticValues
+		s.SampleCount = aws.Float64(aws.Float64Value(s.SampleCount) * weight)
+		s.Sum = aws.Float64(aws.Float64Value(s.Sum) * weight)
+		d.StatisticValues = &s
+	case len(d.Values) > 0:
+		d.Counts = make([]*float64, len(d.Values))
+		for i := range d.Values {
+			count := 1.0
+			if i < len(datum.Counts) {
+				count = aws.Float64Value(datum.Counts[i])
+			}
+
+			d.Counts[i] = aws.Float64(count * weight)
+		}
+	case d.Value != nil:
+		v := *d.Value
+		d.Value = nil
+
+		if b.valueArrays || b.isPercentile(ns, &d) {
+			d.Values = []*float64{aws.Float64(v)}
+			d.Counts = []*float64{aws.Float64(weight)}
+		} else {
+			d.StatisticValues = &cw.StatisticSet{
+				SampleCount: aws.Float64(weight),
+				Sum:         aws.Float64(v * weight),
+				Minimum:     aws.Float64(v),
+				Maximum:     aws.Float64(v),
+			}
+		}
+	}
+
+	b.modified("SampleWeighted")
+
+	return &d
+}
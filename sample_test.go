@@ -0,0 +1,86 @@
+package cwatsch_test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampleRate(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithSampleRate(0.1), cwatsch.WithAggregation())
+
+	datum := cwatsch.Datum("latency").Value(2).Build()
+	for i := 0; i < 10000; i++ {
+		batch.Add("myApp", datum)
+	}
+
+	assert.Less(t, batch.Stats().Queued, int64(2000))
+	assert.Equal(t, 2.0, aws.Float64Value(datum.Value), "caller's datum must not be modified")
+	assert.Nil(t, datum.StatisticValues)
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	require.Len(t, cwAPI.capturedPayloads[0].MetricData, 1)
+
+	stats := cwAPI.capturedPayloads[0].MetricData[0].StatisticValues
+	require.NotNil(t, stats)
+
+	assert.InEpsilon(t, 10000, aws.Float64Value(stats.SampleCount), 0.2)
+	assert.InDelta(t, 2, aws.Float64Value(stats.Sum)/aws.Float64Value(stats.SampleCount), 1e-9)
+	assert.Equal(t, 2.0, aws.Float64Value(stats.Minimum))
+	assert.Equal(t, 2.0, aws.Float64Value(stats.Maximum))
+}
+
+func TestSampleRateWeightsValueArrays(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithSampleRate(0.5), cwatsch.WithValueArrays())
+
+	for i := 0; i < 4000; i++ {
+		batch.Add("myApp", cwatsch.Datum("latency").Value(float64(i%2)).Build())
+	}
+
+	batch.Add("myApp", &cw.MetricDatum{
+		MetricName:      aws.String("requests"),
+		StatisticValues: &cw.StatisticSet{SampleCount: aws.Float64(10), Sum: aws.Float64(30), Minimum: aws.Float64(1), Maximum: aws.Float64(5)},
+	})
+
+	require.NoError(t, batch.Flush())
+
+	total := 0.0
+
+	for _, p := range cwAPI.capturedPayloads {
+		for _, d := range p.MetricData {
+			if aws.StringValue(d.MetricName) == "requests" {
+				assert.Equal(t, 20.0, aws.Float64Value(d.StatisticValues.SampleCount))
+				assert.Equal(t, 60.0, aws.Float64Value(d.StatisticValues.Sum))
+				assert.Equal(t, 5.0, aws.Float64Value(d.StatisticValues.Maximum))
+
+				continue
+			}
+
+			for _, c := range d.Counts {
+				total += aws.Float64Value(c)
+			}
+		}
+	}
+
+	assert.InEpsilon(t, 4000, total, 0.2)
+}
+
+func TestSampleRateOutOfRangeIsOff(t *testing.T) {
+	for _, rate := range []float64{0, 1, 2, -1} {
+		cwAPI := cwMock{}
+		batch := cwatsch.New(&cwAPI, cwatsch.WithSampleRate(rate))
+
+		batch.Add("myApp", metricData("metric", 10)...)
+		require.NoError(t, batch.Flush())
+
+		require.Len(t, cwAPI.capturedPayloads, 1)
+		assert.Len(t, cwAPI.capturedPayloads[0].MetricData, 10, "rate %v", rate)
+	}
+}
@@ -0,0 +1,134 @@
+package cwatsch
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+const metricsModifiedName = "MetricsModified"
+
+// WithInternalMetrics makes the batch report metrics about itself to the given
+// namespace. They are added to the buffer on every flush of all the collected
+// metrics.
+//
+// The metric MetricsModified counts the datums the batch altered before sending
+// them, the kind of the alteration is stored in the dimension Modification:
+// DimensionsTruncated, DimensionsDeduplicated, ValueClamped,
+// ValueConflictResolved, TimestampClamped, TimestampJittered,
+// TimestampBucketed, NamePrefixed, PercentileConverted or SampleWeighted. A
+// datum altered in several ways is counted once per kind. Silent corrections
+// become a visible and alarmable signal this way, pointing to the source
+// producing the faulty metrics.
+func WithInternalMetrics(namespace string) Option {
+	return func(b *Batch) {
+		b.internalNS = aws.String(namespace)
+		b.modifications = map[string]int64{}
+	}
+}
+
+// modified records that a datum has been altered. kind describes the
+// alteration.
+func (b *Batch) modified(kind string) {
+	atomic.AddInt64(&b.counters.modified, 1)
+
+	if b.internalNS == nil {
+		return
+	}
+
+	b.modMu.Lock()
+	b.modifications[kind]++
+	b.modMu.Unlock()
+}
+
+// collectInternal adds the internal metrics to the buffer. Must be called with
+// the lock held.
+func (b *Batch) collectInternal() {
+	if b.internalNS == nil {
+		return
+	}
+
+	b.modMu.Lock()
+	modifications := b.modifications
+	b.modifications = map[string]int64{}
+	b.modMu.Unlock()
+
+	kinds := make([]string, 0, len(modifications))
+	for kind := range modifications {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Strings(kinds)
+
+	now := time.Now()
+	data := make([]*cw.MetricDatum, 0, len(kinds))
+
+	for _, kind := range kinds {
+		data = append(data, &cw.MetricDatum{
+			MetricName: aws.String(metricsModifiedName),
+			Dimensions: []*cw.Dimension{{Name: aws.String("Modification"), Value: aws.String(kind)}},
+			Value:      aws.Float64(float64(modifications[kind])),
+			Unit:       aws.String(cw.StandardUnitCount),
+			Timestamp:  aws.Time(now),
+		})
+	}
+
+	if len(data) == 0 {
+		return
+	}
+
+	// the datums are queued as they are, altering them (e.g. with
+	// WithTimestampBucket) would be counted as a modification on every flush
+	q := b.queue(*b.internalNS)
+	for _, d := range data {
+		q.push(d)
+	}
+
+	q.join(len(data))
+
+	atomic.AddInt64(&b.counters.queued, int64(len(data)))
+	atomic.AddInt64(&b.counters.pending, int64(len(data)))
+}
+
+// WithLivenessMetric makes every flush of all the collected metrics send the
+// datum name with the value 1 (unit Count) to the namespace, even if the buffer
+// holds nothing else. An alarm on missing data of the metric then detects a
+// process that has stopped flushing altogether.
+//
+// The datum bypasses WithEmitOnChange, its whole point is to be sent on every
+// flush.
+func WithLivenessMetric(namespace, name string) Option {
+	return func(b *Batch) {
+		b.liveness = &livenessMetric{namespace: namespace, name: name}
+	}
+}
+
+type livenessMetric struct {
+	namespace string
+	name      string
+}
+
+// collectLiveness adds the liveness datum to the buffer. Must be called with
+// the lock held.
+func (b *Batch) collectLiveness() {
+	if b.liveness == nil {
+		return
+	}
+
+	datum, err := b.prepare(b.liveness.namespace, &cw.MetricDatum{
+		MetricName: aws.String(b.liveness.name),
+		Value:      aws.Float64(1),
+		Unit:       aws.String(cw.StandardUnitCount),
+		Timestamp:  aws.Time(time.Now()),
+	})
+	if err != nil {
+		return
+	}
+
+	b.queue(b.liveness.namespace).push(datum)
+	atomic.AddInt64(&b.counters.queued, 1)
+	atomic.AddInt64(&b.counters.pending, 1)
+}
@@ -0,0 +1,43 @@
+package cwatsch
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// HandleShutdown flushes all the collected metrics when the process receives
+// one of the signals (SIGINT and SIGTERM if none are given), so that the metrics
+// collected since the last flush aren't lost on termination. The flush gives up
+// after 5 seconds, its error is reported via WithOnError.
+//
+// After the flush the signal is passed on: to then if it's not nil, otherwise
+// the handling of the signals is given up and the signal is raised again, so
+// the process terminates the same way it would without the handler. Only the
+// first call installs the handler, the later calls do nothing.
+func (b *Batch) HandleShutdown(then func(os.Signal), signals ...os.Signal) {
+	b.shutdownOnce.Do(func() {
+		if len(signals) == 0 {
+			signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+		}
+
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, signals...)
+
+		go func() {
+			sig := <-c
+
+			b.reportError(b.FlushWithTimeout(finalFlushTimeout))
+			signal.Stop(c)
+
+			if then != nil {
+				then(sig)
+				return
+			}
+
+			if p, err := os.FindProcess(os.Getpid()); err != nil || p.Signal(sig) != nil {
+				os.Exit(1)
+			}
+		}()
+	})
+}
@@ -0,0 +1,50 @@
+package cwatsch_test
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleShutdown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sending signals to the own process isn't supported on windows")
+	}
+
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("requests"), Value: aws.Float64(1)})
+
+	received := make(chan os.Signal, 2)
+	batch.HandleShutdown(func(sig os.Signal) { received <- sig }, os.Interrupt)
+	batch.HandleShutdown(func(sig os.Signal) { received <- sig }, os.Interrupt)
+
+	p, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, p.Signal(os.Interrupt))
+
+	select {
+	case sig := <-received:
+		assert.Equal(t, os.Interrupt, sig)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the signal wasn't passed on")
+	}
+
+	assert.Equal(t, 0, batch.Len())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Equal(t, "requests", aws.StringValue(cwAPI.capturedPayloads[0].MetricData[0].MetricName))
+
+	select {
+	case <-received:
+		t.Fatal("the handler is installed only once")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,120 @@
+package cwatsch
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// maxRequestSize is the max size of a PutMetricData request body CloudWatch
+// accepts (1MB), less some headroom for the namespace and the request's own
+// parameters.
+const maxRequestSize = 1024*1024 - 4*1024
+
+// Approximate lengths of the query parameter names, e.g.
+// "&MetricData.member.20.Dimensions.member.30.Value=".
+const (
+	paramSize      = 40
+	dimParamSize   = 50
+	valueParamSize = 45
+	numberSize     = 24
+)
+
+// WithSizeEstimator replaces the estimate of the size a datum takes in the
+// serialized request, which decides how many datums fit into one request (and
+// when FlushIfLarger flushes). The default estimate, DatumSize, errs on the side
+// of overestimating; fn can be more precise or cheaper for the datums at hand.
+// Underestimating makes CloudWatch reject the requests exceeding its limit of
+// 1MB.
+func WithSizeEstimator(fn func(*cw.MetricDatum) int) Option {
+	return func(b *Batch) {
+		b.sizeEstimator = fn
+	}
+}
+
+// FlushIfLarger flushes all the collected metrics if their estimated size in
+// the serialized requests exceeds maxBytes. Otherwise it does nothing and
+// returns nil. It's meant to be called after adding datums with many or long
+// dimensions, so that the flush happens before a large backlog builds up. The
+// estimate is computed by walking the whole buffer.
+func (b *Batch) FlushIfLarger(maxBytes int) error {
+	b.Lock()
+	size := 0
+	for _, q := range b.metricQs {
+		q.each(func(d *cw.MetricDatum) {
+			size += q.datumSize(d)
+		})
+	}
+	b.Unlock()
+
+	if size <= maxBytes {
+		return nil
+	}
+
+	return b.Flush()
+}
+
+// datumSize estimates the size the datum takes in the serialized request with
+// the estimator of the queue.
+func (q *queue) datumSize(d *cw.MetricDatum) int {
+	if q.sizeOf != nil {
+		return q.sizeOf(d)
+	}
+
+	return DatumSize(d)
+}
+
+// DatumSize estimates the size the datum takes in the serialized request. The
+// estimate errs on the side of overestimating. It's the default estimator of
+// WithSizeEstimator.
+func DatumSize(d *cw.MetricDatum) int {
+	if d == nil {
+		return 0
+	}
+
+	size := paramSize + escapedLen(aws.StringValue(d.MetricName))
+
+	for _, dim := range d.Dimensions {
+		if dim != nil {
+			size += 2*dimParamSize + escapedLen(aws.StringValue(dim.Name)) + escapedLen(aws.StringValue(dim.Value))
+		}
+	}
+
+	if d.Value != nil {
+		size += paramSize + numberSize
+	}
+
+	if d.StatisticValues != nil {
+		size += 4 * (paramSize + numberSize)
+	}
+
+	size += (len(d.Values) + len(d.Counts)) * (valueParamSize + numberSize)
+
+	if d.Unit != nil {
+		size += paramSize + len(*d.Unit)
+	}
+
+	if d.Timestamp != nil {
+		size += paramSize + len("2006-01-02T15:04:05.999999999Z")
+	}
+
+	if d.StorageResolution != nil {
+		size += paramSize + numberSize
+	}
+
+	return size
+}
+
+// escapedLen returns the length of s once it's query-escaped.
+func escapedLen(s string) int {
+	n := len(s)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~') {
+			n += 2
+		}
+	}
+
+	return n
+}
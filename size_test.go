@@ -0,0 +1,100 @@
+package cwatsch_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestSizeIsLimited(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	// 30 dimensions of max length with values that triple in size when
+	// escaped, 20 such datums don't fit into a 1MB request
+	for i := 0; i < 20; i++ {
+		dims := map[string]string{}
+		for j := 0; j < 30; j++ {
+			dims[fmt.Sprintf("%0250d", j)] = strings.Repeat("/", 1024)
+		}
+
+		batch.Add("myApp", &cw.MetricDatum{
+			MetricName: aws.String("metric"),
+			Dimensions: cwatsch.Dimensions(dims),
+			Value:      aws.Float64(1),
+		})
+	}
+
+	require.NoError(t, batch.Flush())
+
+	sizes := payloadSizes(cwAPI.payloads())
+	require.Len(t, sizes, 2)
+	assert.Equal(t, 20, sizes[0]+sizes[1])
+	assert.Less(t, sizes[0], 20)
+}
+
+func TestFlushIfLarger(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	dims := map[string]string{}
+	for j := 0; j < 10; j++ {
+		dims[fmt.Sprintf("dim%d", j)] = strings.Repeat("x", 1000)
+	}
+
+	// every datum takes ~11KB
+	datum := &cw.MetricDatum{
+		MetricName: aws.String("metric"),
+		Dimensions: cwatsch.Dimensions(dims),
+		Value:      aws.Float64(1),
+	}
+
+	for i := 0; i < 4; i++ {
+		batch.Add("myApp", datum)
+		require.NoError(t, batch.FlushIfLarger(50*1024))
+	}
+
+	assert.Empty(t, cwAPI.payloads())
+	assert.Equal(t, 4, batch.Len())
+
+	batch.Add("myApp", datum)
+	require.NoError(t, batch.FlushIfLarger(50*1024))
+
+	assert.Equal(t, []int{5}, payloadSizes(cwAPI.payloads()))
+	assert.Equal(t, 0, batch.Len())
+}
+
+func TestSizeEstimator(t *testing.T) {
+	cwAPI := cwMock{}
+
+	var estimated int
+
+	batch := cwatsch.New(&cwAPI, cwatsch.WithSizeEstimator(func(d *cw.MetricDatum) int {
+		estimated++
+		return 300 * 1024
+	}))
+
+	batch.Add("myApp", metricData("metric", 10)...)
+
+	require.NoError(t, batch.FlushIfLarger(3000*1024))
+	assert.Empty(t, cwAPI.capturedPayloads, "10 datums of 300KB don't exceed 3000KB")
+
+	require.NoError(t, batch.Flush())
+	assert.Equal(t, []int{3, 3, 3, 1}, payloadSizes(sortBySize(cwAPI.capturedPayloads)))
+	assert.Greater(t, estimated, 0)
+}
+
+func TestDatumSize(t *testing.T) {
+	small := cwatsch.Datum("m").Value(1).Build()
+	large := cwatsch.Datum("m").Value(1).Dim("endpoint", strings.Repeat("/", 100)).Build()
+
+	assert.Greater(t, cwatsch.DatumSize(small), 0)
+	assert.Greater(t, cwatsch.DatumSize(large), cwatsch.DatumSize(small)+300, "escaped characters count triple")
+	assert.Zero(t, cwatsch.DatumSize(nil))
+}
@@ -0,0 +1,294 @@
+package cwatsch
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// Stats holds the counters describing what the batch has been doing. The
+// counters are maintained atomically, so reading them doesn't contend with
+// adding metrics and is cheap enough for a frequently polled dashboard.
+type Stats struct {
+	// Queued is the number of datums accepted into the buffer. Datums rejected
+	// when added (see DatumError) or suppressed by WithEmitOnChange are not
+	// counted. Accepted datums may still be merged into others (see
+	// WithAggregation) or discarded when their queue is full (see
+	// WithMaxQueueSize).
+	Queued int64
+	// APICalls is the number of PutMetricData requests made.
+	APICalls int64
+	// MetricsSent is the number of datums successfully sent to CloudWatch.
+	MetricsSent int64
+	// Dropped is the number of datums that were discarded without being sent,
+	// e.g. because the request carrying them failed.
+	Dropped int64
+	// FlushErrors is the number of failed PutMetricData requests.
+	FlushErrors int64
+	// Overflowed is the number of datums discarded because their queue was
+	// full, see WithMaxQueueSize. They are included in Dropped as well.
+	Overflowed int64
+	// Modified is the number of alterations the batch made to datums before
+	// sending them. See WithInternalMetrics for the breakdown by kind.
+	Modified int64
+
+	// Pending is the number of datums waiting in the buffer. It's an estimate,
+	// since metrics may be added or flushed while the stats are being read.
+	Pending int64
+	// LastFlushSent is the number of datums sent by the most recent flush.
+	LastFlushSent int64
+	// LastFlush is the time the most recent flush completed. It's zero if the
+	// batch hasn't been flushed yet.
+	LastFlush time.Time
+}
+
+type counters struct {
+	queued        int64
+	apiCalls      int64
+	metricsSent   int64
+	dropped       int64
+	flushErrors   int64
+	overflowed    int64
+	modified      int64
+	pending       int64
+	lastFlushSent int64
+	lastFlushAt   int64
+}
+
+// Stats returns the current values of the batch's counters.
+func (b *Batch) Stats() Stats {
+	stats := Stats{
+		Queued:        atomic.LoadInt64(&b.counters.queued),
+		APICalls:      atomic.LoadInt64(&b.counters.apiCalls),
+		MetricsSent:   atomic.LoadInt64(&b.counters.metricsSent),
+		Dropped:       atomic.LoadInt64(&b.counters.dropped),
+		FlushErrors:   atomic.LoadInt64(&b.counters.flushErrors),
+		Overflowed:    atomic.LoadInt64(&b.counters.overflowed),
+		Modified:      atomic.LoadInt64(&b.counters.modified),
+		Pending:       atomic.LoadInt64(&b.counters.pending),
+		LastFlushSent: atomic.LoadInt64(&b.counters.lastFlushSent),
+	}
+
+	if lastFlushAt := atomic.LoadInt64(&b.counters.lastFlushAt); lastFlushAt != 0 {
+		stats.LastFlush = time.Unix(0, lastFlushAt)
+	}
+
+	return stats
+}
+
+// PublishExpvar publishes the stats of the batch as the expvar variable name, so
+// that they appear on /debug/vars as a JSON object with the fields of Stats.
+// The stats are read whenever the variable is. Like expvar.Publish, it panics
+// if the name is already in use.
+func (b *Batch) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return b.Stats()
+	}))
+}
+
+// ResetStats zeros the Queued, APICalls, MetricsSent, Dropped, FlushErrors, Overflowed
+// and Modified counters. It's handy for periodic reporting windows and for
+// isolating test assertions. Only the observability counters are reset, the
+// buffered metrics (and the Pending estimate reflecting them) are left
+// untouched.
+func (b *Batch) ResetStats() {
+	atomic.StoreInt64(&b.counters.queued, 0)
+	atomic.StoreInt64(&b.counters.apiCalls, 0)
+	atomic.StoreInt64(&b.counters.metricsSent, 0)
+	atomic.StoreInt64(&b.counters.dropped, 0)
+	atomic.StoreInt64(&b.counters.flushErrors, 0)
+	atomic.StoreInt64(&b.counters.overflowed, 0)
+	atomic.StoreInt64(&b.counters.modified, 0)
+
+	b.nsStatsMu.Lock()
+	b.nsStats = nil
+	b.nsStatsMu.Unlock()
+}
+
+// NamespaceStat describes the requests sent for one namespace.
+type NamespaceStat struct {
+	// Requests is the number of PutMetricData requests made.
+	Requests int64
+	// Errors is the number of failed requests.
+	Errors int64
+	// Latency is the total time spent sending the requests.
+	Latency time.Duration
+	// MaxLatency is the duration of the slowest request.
+	MaxLatency time.Duration
+}
+
+// AvgLatency returns the average duration of a request.
+func (s NamespaceStat) AvgLatency() time.Duration {
+	if s.Requests == 0 {
+		return 0
+	}
+
+	return s.Latency / time.Duration(s.Requests)
+}
+
+// NamespaceStats returns the request counts and latencies broken down by
+// namespace. It helps to find out which namespace is slow or expensive in a
+// multi-namespace setup. The stats are reset by ResetStats.
+func (b *Batch) NamespaceStats() map[string]NamespaceStat {
+	b.nsStatsMu.Lock()
+	defer b.nsStatsMu.Unlock()
+
+	stats := make(map[string]NamespaceStat, len(b.nsStats))
+	for ns, s := range b.nsStats {
+		stats[ns] = s
+	}
+
+	return stats
+}
+
+func (b *Batch) observeNamespace(ns string, latency time.Duration, err error) {
+	b.nsStatsMu.Lock()
+	defer b.nsStatsMu.Unlock()
+
+	if b.nsStats == nil {
+		b.nsStats = map[string]NamespaceStat{}
+	}
+
+	s := b.nsStats[ns]
+	s.Requests++
+	s.Latency += latency
+
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+
+	if err != nil {
+		s.Errors++
+	}
+
+	b.nsStats[ns] = s
+}
+
+// Len returns the number of datums queued across all the namespaces. Unlike
+// Stats().Pending it's exact, but it takes the lock and thus contends with
+// adding metrics. Datums taken out of the queues by a running flush are not
+// included.
+func (b *Batch) Len() int {
+	b.Lock()
+	defer b.Unlock()
+
+	n := 0
+	for _, q := range b.metricQs {
+		n += q.count
+	}
+
+	return n
+}
+
+// Pending returns copies of the datums queued for the namespace, in the order
+// they are going to be sent, without flushing them. It's meant for inspecting
+// the buffer in tests. The copies can be modified freely. Datums taken out of
+// the queue by a running flush are not included.
+func (b *Batch) Pending(namespace string) []*cw.MetricDatum {
+	b.Lock()
+	defer b.Unlock()
+
+	q, ok := b.metricQs[namespace]
+	if !ok {
+		return nil
+	}
+
+	data := make([]*cw.MetricDatum, 0, q.count)
+	q.each(func(d *cw.MetricDatum) {
+		data = append(data, copyDatum(d))
+	})
+
+	return data
+}
+
+// copyDatum returns a deep copy of the datum.
+func copyDatum(d *cw.MetricDatum) *cw.MetricDatum {
+	if d == nil {
+		return nil
+	}
+
+	c := *d
+	c.MetricName = copyString(d.MetricName)
+	c.Unit = copyString(d.Unit)
+
+	if d.Dimensions != nil {
+		c.Dimensions = make([]*cw.Dimension, len(d.Dimensions))
+		for i, dim := range d.Dimensions {
+			if dim != nil {
+				c.Dimensions[i] = &cw.Dimension{Name: copyString(dim.Name), Value: copyString(dim.Value)}
+			}
+		}
+	}
+
+	if d.StatisticValues != nil {
+		s := *d.StatisticValues
+		s.SampleCount = copyFloat(s.SampleCount)
+		s.Sum = copyFloat(s.Sum)
+		s.Minimum = copyFloat(s.Minimum)
+		s.Maximum = copyFloat(s.Maximum)
+		c.StatisticValues = &s
+	}
+
+	c.Value = copyFloat(d.Value)
+	c.Values = copyFloats(d.Values)
+	c.Counts = copyFloats(d.Counts)
+
+	if d.Timestamp != nil {
+		c.Timestamp = aws.Time(*d.Timestamp)
+	}
+
+	if d.StorageResolution != nil {
+		c.StorageResolution = aws.Int64(*d.StorageResolution)
+	}
+
+	return &c
+}
+
+func copyString(s *string) *string {
+	if s == nil {
+		return nil
+	}
+
+	return aws.String(*s)
+}
+
+func copyFloat(f *float64) *float64 {
+	if f == nil {
+		return nil
+	}
+
+	return aws.Float64(*f)
+}
+
+func copyFloats(fs []*float64) []*float64 {
+	if fs == nil {
+		return nil
+	}
+
+	c := make([]*float64, len(fs))
+	for i, f := range fs {
+		c[i] = copyFloat(f)
+	}
+
+	return c
+}
+
+// LenByNamespace returns the number of datums queued per namespace, see Len.
+// Namespaces without queued datums are omitted.
+func (b *Batch) LenByNamespace() map[string]int {
+	b.Lock()
+	defer b.Unlock()
+
+	lens := make(map[string]int, len(b.metricQs))
+
+	for ns, q := range b.metricQs {
+		if q.count > 0 {
+			lens[ns] = q.count
+		}
+	}
+
+	return lens
+}
@@ -0,0 +1,270 @@
+package cwatsch_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/molecule-man/cwatsch/cwatschtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	for i := 0; i < 25; i++ {
+		batch.Add("", &cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("metric%d", i))})
+	}
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(25), stats.Queued)
+	assert.Equal(t, int64(25), stats.Pending)
+	assert.True(t, stats.LastFlush.IsZero())
+
+	before := time.Now()
+
+	require.NoError(t, batch.Flush())
+
+	stats = batch.Stats()
+	assert.Equal(t, int64(2), stats.APICalls)
+	assert.Equal(t, int64(25), stats.MetricsSent)
+	assert.Equal(t, int64(0), stats.Dropped)
+	assert.Equal(t, int64(0), stats.FlushErrors)
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.Equal(t, int64(25), stats.LastFlushSent)
+	assert.False(t, stats.LastFlush.Before(before))
+}
+
+func TestStatsQueued(t *testing.T) {
+	batch := cwatsch.New(&cwMock{})
+
+	batch.Add("myApp", metricData("metric", 3)...)
+	batch.Add("myApp", cwatsch.Datum("rejected").Dim("zone", "").Build())
+
+	require.NoError(t, batch.Flush())
+	batch.Add("myApp", metricData("metric", 1)...)
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(4), stats.Queued)
+	assert.Equal(t, int64(1), stats.Dropped)
+
+	batch.ResetStats()
+	assert.Equal(t, int64(0), batch.Stats().Queued)
+}
+
+func TestStatsCountFailures(t *testing.T) {
+	cwAPI := cwMock{err: errors.New("boom")}
+	batch := cwatsch.New(&cwAPI)
+
+	batch.Add("", &cw.MetricDatum{MetricName: aws.String("metric1")}, &cw.MetricDatum{MetricName: aws.String("metric2")})
+
+	require.Error(t, batch.Flush())
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(1), stats.APICalls)
+	assert.Equal(t, int64(0), stats.MetricsSent)
+	assert.Equal(t, int64(2), stats.Dropped)
+	assert.Equal(t, int64(1), stats.FlushErrors)
+	assert.Equal(t, int64(0), stats.LastFlushSent)
+}
+
+func TestStatsPendingAfterFlushingCompleteBatches(t *testing.T) {
+	batch := cwatsch.New(&cwMock{})
+
+	for i := 0; i < 25; i++ {
+		batch.Add("", &cw.MetricDatum{MetricName: aws.String(fmt.Sprintf("metric%d", i))})
+	}
+
+	require.NoError(t, batch.FlushCompleteBatches())
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(5), stats.Pending)
+	assert.Equal(t, int64(20), stats.LastFlushSent)
+}
+
+func TestResetStatsKeepsBufferedMetrics(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	batch.Add("", &cw.MetricDatum{MetricName: aws.String("metric1")})
+	require.NoError(t, batch.Flush())
+
+	batch.Add("", &cw.MetricDatum{MetricName: aws.String("metric2")})
+	batch.ResetStats()
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(0), stats.APICalls)
+	assert.Equal(t, int64(0), stats.MetricsSent)
+	assert.Equal(t, int64(1), stats.Pending)
+
+	require.NoError(t, batch.Flush())
+
+	stats = batch.Stats()
+	assert.Equal(t, int64(1), stats.APICalls)
+	assert.Equal(t, int64(1), stats.MetricsSent)
+	assert.Len(t, cwAPI.capturedPayloads, 2)
+}
+
+func TestStatsWithFailingNamespace(t *testing.T) {
+	client := &cwatschtest.FlakyClient{FailNamespace: "broken"}
+	batch := cwatsch.New(client)
+
+	batch.Add("broken", metricData("metric", 3)...)
+	batch.Add("fine", metricData("metric", 25)...)
+
+	require.Error(t, batch.Flush())
+
+	stats := batch.Stats()
+	assert.Equal(t, int64(3), stats.Dropped)
+	assert.Equal(t, int64(1), stats.FlushErrors)
+	sent := 0
+	for _, input := range client.Inputs() {
+		sent += len(input.MetricData)
+	}
+
+	assert.Equal(t, int64(sent), stats.MetricsSent)
+	assert.Equal(t, int64(25-sent), stats.Pending, "batches cancelled by the failure stay buffered")
+}
+
+func TestNamespaceStats(t *testing.T) {
+	batch := cwatsch.New(nil)
+
+	batch.Add("slow", metricData("metric", 25)...)
+	batch.Add("fast", metricData("metric", 3)...)
+	batch.Add("broken", metricData("metric", 1)...)
+
+	err := batch.FlushTo(context.Background(), func(_ context.Context, input *cw.PutMetricDataInput) error {
+		switch aws.StringValue(input.Namespace) {
+		case "slow":
+			time.Sleep(20 * time.Millisecond)
+		case "broken":
+			// the other namespaces are done before the failure cancels them
+			time.Sleep(50 * time.Millisecond)
+			return errors.New("broken")
+		}
+
+		return nil
+	})
+	require.Error(t, err)
+
+	stats := batch.NamespaceStats()
+	require.Len(t, stats, 3)
+
+	assert.Equal(t, int64(2), stats["slow"].Requests)
+	assert.Equal(t, int64(0), stats["slow"].Errors)
+	assert.GreaterOrEqual(t, int64(stats["slow"].MaxLatency), int64(20*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(stats["slow"].AvgLatency()), int64(20*time.Millisecond))
+
+	assert.Equal(t, int64(1), stats["fast"].Requests)
+	assert.Less(t, int64(stats["fast"].MaxLatency), int64(20*time.Millisecond))
+
+	assert.Equal(t, int64(1), stats["broken"].Errors)
+
+	batch.ResetStats()
+	assert.Empty(t, batch.NamespaceStats())
+}
+
+func TestLen(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	assert.Equal(t, 0, batch.Len())
+	assert.Empty(t, batch.LenByNamespace())
+
+	batch.Add("myApp", metricData("metric", 25)...)
+	batch.Add("other", metricData("metric", 3)...)
+
+	assert.Equal(t, 28, batch.Len())
+	assert.Equal(t, map[string]int{"myApp": 25, "other": 3}, batch.LenByNamespace())
+
+	require.NoError(t, batch.FlushCompleteBatches())
+
+	assert.Equal(t, 8, batch.Len())
+	assert.Equal(t, map[string]int{"myApp": 5, "other": 3}, batch.LenByNamespace())
+}
+
+// expvarRuns makes the names published by the tests unique, expvar doesn't
+// allow publishing a name twice, e.g. with -count.
+var expvarRuns int64
+
+func TestPublishExpvar(t *testing.T) {
+	name := fmt.Sprintf("%s_%d", t.Name(), atomic.AddInt64(&expvarRuns, 1))
+
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+	batch.PublishExpvar(name)
+
+	read := func() cwatsch.Stats {
+		var stats cwatsch.Stats
+
+		require.NoError(t, json.Unmarshal([]byte(expvar.Get(name).String()), &stats))
+
+		return stats
+	}
+
+	assert.Equal(t, cwatsch.Stats{}, read())
+
+	batch.Add("myApp", metricData("metric", 3)...)
+	assert.Equal(t, int64(3), read().Queued)
+	assert.Equal(t, int64(3), read().Pending)
+
+	require.NoError(t, batch.Flush())
+
+	stats := read()
+	assert.Equal(t, int64(3), stats.MetricsSent)
+	assert.Equal(t, int64(1), stats.APICalls)
+	assert.Zero(t, stats.Pending)
+	assert.False(t, stats.LastFlush.IsZero())
+
+	assert.Panics(t, func() { batch.PublishExpvar(name) })
+}
+
+func TestPending(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithAggregation())
+
+	assert.Empty(t, batch.Pending("myApp"))
+
+	batch.Add("myApp",
+		cwatsch.Datum("calls").Value(1).Dim("service", "api").Build(),
+		cwatsch.Datum("calls").Value(3).Dim("service", "api").Build(),
+		cwatsch.Datum("errors").Value(1).Build(),
+	)
+	batch.Add("other", metricData("metric", 1)...)
+
+	pending := batch.Pending("myApp")
+	require.Len(t, pending, 2)
+	assert.Equal(t, "calls", aws.StringValue(pending[0].MetricName))
+	assert.Equal(t, 4.0, aws.Float64Value(pending[0].StatisticValues.Sum))
+	assert.Equal(t, "errors", aws.StringValue(pending[1].MetricName))
+
+	*pending[0].StatisticValues.Sum = 100
+	*pending[0].Dimensions[0].Value = "changed"
+	pending[1].MetricName = aws.String("changed")
+
+	assert.Equal(t, 3, batch.Len(), "inspecting doesn't flush")
+
+	require.NoError(t, batch.Flush())
+
+	data := map[string]*cw.MetricDatum{}
+	for _, p := range cwAPI.capturedPayloads {
+		for _, d := range p.MetricData {
+			data[aws.StringValue(d.MetricName)] = d
+		}
+	}
+
+	require.Contains(t, data, "calls")
+	assert.Equal(t, 4.0, aws.Float64Value(data["calls"].StatisticValues.Sum))
+	assert.Equal(t, "api", aws.StringValue(data["calls"].Dimensions[0].Value))
+	assert.Contains(t, data, "errors")
+}
@@ -0,0 +1,53 @@
+package cwatsch_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTickerImmediate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	cwatsch.NewTickerImmediate(ctx, time.Hour, func() {
+		calls++
+		cancel()
+	})
+
+	assert.Equal(t, 1, calls)
+
+	cwatsch.NewTickerImmediate(ctx, time.Hour, func() { calls++ })
+	assert.Equal(t, 1, calls, "fn isn't called once ctx is done")
+}
+
+func TestNewTickerDoesNotDrift(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls []time.Time
+
+	start := time.Now()
+
+	cwatsch.NewTicker(ctx, 20*time.Millisecond, func() {
+		calls = append(calls, time.Now())
+		if len(calls) == 5 {
+			cancel()
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	// with the interval counted from the end of the previous call, the fifth
+	// call would happen after 5*20ms + 4*10ms
+	assert.Len(t, calls, 5)
+	assert.Less(t, int64(calls[4].Sub(start)), int64(130*time.Millisecond))
+
+	for i := 1; i < len(calls); i++ {
+		assert.GreaterOrEqual(t, int64(calls[i].Sub(calls[i-1])), int64(15*time.Millisecond))
+	}
+}
@@ -0,0 +1,162 @@
+package cwatsch
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// The window of timestamps CloudWatch accepts relative to the current time.
+const (
+	maxTimestampAge   = 14 * 24 * time.Hour
+	maxTimestampAhead = 2 * time.Hour
+)
+
+// WithTimestampClamping makes the batch move the timestamps outside of the
+// window CloudWatch accepts (two weeks in the past to two hours in the future)
+// to the nearest edge of the window. Clamped datums are counted as
+// modifications (see WithInternalMetrics).
+//
+// By default such datums are dropped and reported as *DatumError via
+// WithOnError, so that one bad datum doesn't fail the whole request. The
+// timestamps are checked right before the datums are sent, not when they are
+// added.
+func WithTimestampClamping() Option {
+	return func(b *Batch) {
+		b.clampTimestamps = true
+	}
+}
+
+// checkTimestamps returns the datums of the batch whose timestamps are within
+// the accepted window, clamped into it if WithTimestampClamping is used. The
+// check runs right before the batch is sent, so that the datums that have
+// spent long in the buffer (e.g. requeued after failures) are covered as well.
+// The dropped datums are counted and reported, the caller's datums are not
+// modified.
+func (b *Batch) checkTimestamps(ns string, batch []*cw.MetricDatum) []*cw.MetricDatum {
+	now := time.Now()
+	checked := batch
+	copied := false
+
+	for i, datum := range batch {
+		d, err := b.checkTimestamp(ns, datum, now)
+		if d == datum {
+			if copied {
+				checked = append(checked, d)
+			}
+
+			continue
+		}
+
+		if !copied {
+			checked = append(make([]*cw.MetricDatum, 0, len(batch)), batch[:i]...)
+			copied = true
+		}
+
+		b.forgetAttempt(datum)
+
+		if err != nil {
+			atomic.AddInt64(&b.counters.dropped, 1)
+			b.reportError(err)
+
+			continue
+		}
+
+		checked = append(checked, d)
+	}
+
+	return checked
+}
+
+// checkTimestamp returns the datum if its timestamp is within the accepted
+// window, or its copy with the timestamp clamped into the window.
+func (b *Batch) checkTimestamp(ns string, datum *cw.MetricDatum, now time.Time) (*cw.MetricDatum, error) {
+	if datum == nil || datum.Timestamp == nil {
+		return datum, nil
+	}
+
+	// a minute of margin for the time the request takes
+	oldest := now.Add(-maxTimestampAge + time.Minute)
+	latest := now.Add(maxTimestampAhead - time.Minute)
+
+	var clamped time.Time
+
+	switch ts := *datum.Timestamp; {
+	case ts.Before(oldest):
+		if !b.clampTimestamps {
+			return nil, &DatumError{Namespace: ns, Datum: datum, Reason: "timestamp is older than two weeks"}
+		}
+
+		clamped = oldest
+	case ts.After(latest):
+		if !b.clampTimestamps {
+			return nil, &DatumError{Namespace: ns, Datum: datum, Reason: "timestamp is more than two hours ahead"}
+		}
+
+		clamped = latest
+	default:
+		return datum, nil
+	}
+
+	d := *datum
+	d.Timestamp = aws.Time(clamped)
+	b.modified("TimestampClamped")
+
+	return &d, nil
+}
+
+// WithTimestampJitter shifts the timestamp of every datum by a random offset of
+// up to max in either direction. When many instances emit exactly at the
+// interval boundary, CloudWatch may bucket their datapoints inconsistently;
+// spreading the timestamps improves the quality of fleet-wide aggregation. The
+// offset never moves a timestamp out of its resolution bucket (the minute, or
+// the second for high-resolution metrics). Datums without a timestamp get the
+// current time before being shifted.
+func WithTimestampJitter(max time.Duration) Option {
+	return func(b *Batch) {
+		b.timestampJitter = max
+	}
+}
+
+// WithTimestampBucket rounds the timestamp of every datum down to the start of
+// its bucket of the given size, e.g. time.Minute moves all the datums of a
+// minute to its first second. Datums that differ only slightly in their
+// timestamps then share one and get merged by WithAggregation or
+// WithValueArrays, which reduces the number of datapoints sent. Datums without
+// a timestamp get the current time before being rounded. The rounding is
+// applied after WithTimestampJitter, so a bucket as large as the resolution
+// cancels the jitter.
+func WithTimestampBucket(size time.Duration) Option {
+	return func(b *Batch) {
+		b.timestampBucket = size
+	}
+}
+
+func resolution(d *cw.MetricDatum) time.Duration {
+	if aws.Int64Value(d.StorageResolution) == 1 {
+		return time.Second
+	}
+
+	return time.Minute
+}
+
+// jitter returns a random time within max from t that stays in the same bucket
+// of the given resolution.
+func jitter(t time.Time, max, res time.Duration) time.Time {
+	start := t.Truncate(res)
+
+	lo := t.Add(-max)
+	if lo.Before(start) {
+		lo = start
+	}
+
+	hi := t.Add(max)
+	if end := start.Add(res - 1); hi.After(end) {
+		hi = end
+	}
+
+	return lo.Add(time.Duration(rand.Int63n(int64(hi.Sub(lo)) + 1)))
+}
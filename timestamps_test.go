@@ -0,0 +1,145 @@
+package cwatsch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimestampJitterStaysInBucket(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithTimestampJitter(time.Second))
+
+	hourAgo := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	minuteEdge := hourAgo.Add(59*time.Second + 900*time.Millisecond)
+	secondEdge := hourAgo.Add(100 * time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		batch.Add("standard", cwatsch.Datum("m").At(minuteEdge).Build())
+		batch.Add("highres", &cw.MetricDatum{
+			MetricName:        aws.String("m"),
+			Timestamp:         aws.Time(secondEdge),
+			StorageResolution: aws.Int64(1),
+		})
+	}
+
+	require.NoError(t, batch.Flush())
+
+	shifted := false
+
+	for _, p := range cwAPI.capturedPayloads {
+		for _, d := range p.MetricData {
+			ts := aws.TimeValue(d.Timestamp)
+
+			if aws.StringValue(p.Namespace) == "standard" {
+				assert.False(t, ts.Before(minuteEdge.Add(-time.Second)))
+				assert.Equal(t, minuteEdge.Truncate(time.Minute), ts.Truncate(time.Minute))
+				shifted = shifted || !ts.Equal(minuteEdge)
+			} else {
+				assert.Equal(t, secondEdge.Truncate(time.Second), ts.Truncate(time.Second))
+			}
+		}
+	}
+
+	assert.True(t, shifted)
+}
+
+func TestTimestampJitterSetsMissingTimestamp(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithTimestampJitter(time.Second))
+
+	datum := &cw.MetricDatum{MetricName: aws.String("m")}
+	batch.Add("ns", datum)
+
+	require.NoError(t, batch.Flush())
+
+	assert.NotNil(t, cwAPI.capturedPayloads[0].MetricData[0].Timestamp)
+	assert.Nil(t, datum.Timestamp, "caller's datum must not be modified")
+}
+
+func TestTimestampBucket(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithTimestampBucket(time.Minute))
+
+	hourAgo := time.Now().Add(-time.Hour).Truncate(time.Minute)
+
+	batch.Add("myApp",
+		cwatsch.Datum("m").Value(1).At(hourAgo.Add(1500*time.Millisecond)).Build(),
+		cwatsch.Datum("m").Value(2).At(hourAgo.Add(45*time.Second)).Build(),
+		cwatsch.Datum("m").Value(3).At(hourAgo.Add(70*time.Second)).Build(),
+	)
+
+	datum := &cw.MetricDatum{MetricName: aws.String("m"), Value: aws.Float64(4)}
+	batch.Add("myApp", datum)
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 4)
+
+	assert.Equal(t, hourAgo, aws.TimeValue(data[0].Timestamp))
+	assert.Equal(t, hourAgo, aws.TimeValue(data[1].Timestamp))
+	assert.Equal(t, hourAgo.Add(time.Minute), aws.TimeValue(data[2].Timestamp))
+
+	ts := aws.TimeValue(data[3].Timestamp)
+	assert.Equal(t, ts.Truncate(time.Minute), ts, "missing timestamps are set and rounded")
+	assert.Nil(t, datum.Timestamp, "caller's datum must not be modified")
+}
+
+func TestTimestampsOutsideOfWindowAreDropped(t *testing.T) {
+	cwAPI := cwMock{}
+
+	var errs []error
+
+	batch := cwatsch.New(&cwAPI, cwatsch.WithOnError(func(err error) { errs = append(errs, err) }))
+
+	batch.Add("myApp",
+		cwatsch.Datum("old").Value(1).At(time.Now().Add(-21*24*time.Hour)).Build(),
+		cwatsch.Datum("future").Value(1).At(time.Now().Add(3*time.Hour)).Build(),
+		cwatsch.Datum("fine").Value(1).Build(),
+	)
+	assert.Equal(t, 3, batch.Len(), "the timestamps are checked on flush")
+	assert.Empty(t, errs)
+
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	require.Len(t, cwAPI.capturedPayloads[0].MetricData, 1)
+	assert.Equal(t, "fine", aws.StringValue(cwAPI.capturedPayloads[0].MetricData[0].MetricName))
+
+	require.Len(t, errs, 2)
+	assert.Contains(t, errs[0].Error(), "older than two weeks")
+	assert.Contains(t, errs[1].Error(), "two hours ahead")
+	assert.Equal(t, int64(2), batch.Stats().Dropped)
+}
+
+func TestTimestampClamping(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithTimestampClamping())
+
+	old := cwatsch.Datum("old").Value(1).At(time.Now().Add(-21 * 24 * time.Hour)).Build()
+	future := cwatsch.Datum("future").Value(1).At(time.Now().Add(3 * time.Hour)).Build()
+	origOld := *old.Timestamp
+
+	batch.Add("myApp", old, future)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 2)
+
+	now := time.Now()
+	assert.WithinDuration(t, now.Add(-14*24*time.Hour), aws.TimeValue(data[0].Timestamp), 2*time.Minute)
+	assert.True(t, aws.TimeValue(data[0].Timestamp).After(now.Add(-14*24*time.Hour)))
+	assert.WithinDuration(t, now.Add(2*time.Hour), aws.TimeValue(data[1].Timestamp), 2*time.Minute)
+	assert.True(t, aws.TimeValue(data[1].Timestamp).Before(now.Add(2*time.Hour)))
+
+	assert.Equal(t, origOld, *old.Timestamp, "caller's datum is not modified")
+	assert.Equal(t, int64(2), batch.Stats().Modified)
+}
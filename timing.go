@@ -0,0 +1,163 @@
+package cwatsch
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// timingReservoirSize is the max number of durations kept per timing metric
+// for the computation of the percentiles.
+const timingReservoirSize = 1024
+
+type timing struct {
+	namespace string
+	name      string
+	dims      []*cw.Dimension
+
+	count    int64
+	sum      float64
+	min, max float64
+	samples  []float64
+}
+
+// WithTimingPercentiles makes every timing metric (see Timing) be accompanied
+// by the given percentiles of the durations, computed on the client. They are
+// sent as separate metrics named after the timing metric with the percentile
+// appended, e.g. "latencyP99" or "latencyP99.9".
+func WithTimingPercentiles(percentiles ...float64) Option {
+	return func(b *Batch) {
+		b.timingPercentiles = percentiles
+	}
+}
+
+// Timing records a duration of the metric, e.g. the latency of a request. The
+// durations recorded since the last flush of all the collected metrics are sent
+// as one datum carrying a statistic set (sample count, sum, min and max) in
+// milliseconds, instead of one datum per duration. The order of the dimensions
+// doesn't matter.
+//
+// For the percentiles requested by WithTimingPercentiles, up to 1024 durations
+// are kept per metric and flush. Once that many are recorded, the kept ones are
+// replaced at random so that every recorded duration has the same chance to be
+// kept (reservoir sampling). The percentiles are exact up to 1024 durations and
+// estimated beyond, the statistic set is always exact.
+func (b *Batch) Timing(namespace, name string, d time.Duration, dims ...*cw.Dimension) {
+	ms := float64(d) / float64(time.Millisecond)
+	key := namespace + "\x00" + seriesKey(&cw.MetricDatum{MetricName: &name, Dimensions: dims})
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.timings == nil {
+		b.timings = map[string]*timing{}
+	}
+
+	t, ok := b.timings[key]
+	if !ok {
+		t = &timing{
+			namespace: namespace,
+			name:      name,
+			dims:      append([]*cw.Dimension(nil), dims...),
+			min:       ms,
+			max:       ms,
+		}
+		b.timings[key] = t
+	}
+
+	t.count++
+	t.sum += ms
+	t.min = math.Min(t.min, ms)
+	t.max = math.Max(t.max, ms)
+
+	if len(b.timingPercentiles) == 0 {
+		return
+	}
+
+	if len(t.samples) < timingReservoirSize {
+		t.samples = append(t.samples, ms)
+	} else if i := rand.Int63n(t.count); i < timingReservoirSize {
+		t.samples[i] = ms
+	}
+}
+
+// collectTimings adds the statistics of the recorded durations to the buffer
+// and returns the errors of the rejected datums. Must be called with the lock
+// held.
+func (b *Batch) collectTimings() []error {
+	if len(b.timings) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(b.timings))
+	for key := range b.timings {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	now := time.Now()
+
+	var errs []error
+
+	for _, key := range keys {
+		t := b.timings[key]
+
+		data := []*cw.MetricDatum{{
+			MetricName: aws.String(t.name),
+			Dimensions: t.dims,
+			StatisticValues: &cw.StatisticSet{
+				SampleCount: aws.Float64(float64(t.count)),
+				Sum:         aws.Float64(t.sum),
+				Minimum:     aws.Float64(t.min),
+				Maximum:     aws.Float64(t.max),
+			},
+			Unit:      aws.String(cw.StandardUnitMilliseconds),
+			Timestamp: aws.Time(now),
+		}}
+
+		sort.Float64s(t.samples)
+
+		for _, p := range b.timingPercentiles {
+			data = append(data, &cw.MetricDatum{
+				MetricName: aws.String(t.name + "P" + strconv.FormatFloat(p, 'f', -1, 64)),
+				Dimensions: t.dims,
+				Value:      aws.Float64(percentile(t.samples, p)),
+				Unit:       aws.String(cw.StandardUnitMilliseconds),
+				Timestamp:  aws.Time(now),
+			})
+		}
+
+		errs = append(errs, b.addLocked(&cw.PutMetricDataInput{
+			Namespace:  aws.String(t.namespace),
+			MetricData: data,
+		})...)
+	}
+
+	b.timings = nil
+
+	return errs
+}
+
+// percentile returns the p-th percentile (nearest rank) of the sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+
+	switch {
+	case rank < 0:
+		rank = 0
+	case rank >= len(sorted):
+		rank = len(sorted) - 1
+	}
+
+	return sorted[rank]
+}
@@ -0,0 +1,74 @@
+package cwatsch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTiming(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithTimingPercentiles(50, 99.9))
+
+	for i := 1; i <= 100; i++ {
+		batch.Timing("myApp", "latency", time.Duration(i)*time.Millisecond)
+	}
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 3)
+
+	assert.Equal(t, "latency", aws.StringValue(data[0].MetricName))
+	assert.Equal(t, cw.StandardUnitMilliseconds, aws.StringValue(data[0].Unit))
+	assert.Equal(t, &cw.StatisticSet{
+		SampleCount: aws.Float64(100),
+		Sum:         aws.Float64(5050),
+		Minimum:     aws.Float64(1),
+		Maximum:     aws.Float64(100),
+	}, data[0].StatisticValues)
+
+	assert.Equal(t, "latencyP50", aws.StringValue(data[1].MetricName))
+	assert.Equal(t, 50.0, aws.Float64Value(data[1].Value))
+	assert.Equal(t, "latencyP99.9", aws.StringValue(data[2].MetricName))
+	assert.Equal(t, 100.0, aws.Float64Value(data[2].Value))
+
+	require.NoError(t, batch.Flush())
+	assert.Len(t, cwAPI.capturedPayloads, 1, "the durations are cleared on flush")
+}
+
+func TestTimingReservoir(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithTimingPercentiles(50))
+
+	for i := 0; i < 100000; i++ {
+		batch.Timing("myApp", "latency", time.Duration(i%1000)*time.Millisecond)
+	}
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 2)
+
+	assert.Equal(t, 100000.0, aws.Float64Value(data[0].StatisticValues.SampleCount), "the statistic set is exact")
+	assert.InDelta(t, 500, aws.Float64Value(data[1].Value), 100, "the median is estimated from the sample")
+}
+
+func TestTimingWithoutPercentiles(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	batch.Timing("myApp", "latency", 1500*time.Microsecond, cwatsch.Dimensions(map[string]string{"endpoint": "/users"})...)
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	require.Len(t, cwAPI.capturedPayloads[0].MetricData, 1)
+	assert.Equal(t, 1.5, aws.Float64Value(cwAPI.capturedPayloads[0].MetricData[0].StatisticValues.Sum))
+}
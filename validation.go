@@ -0,0 +1,155 @@
+package cwatsch
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// validateDimensions returns an error if a dimension of the datum has an empty
+// (or whitespace only) value. CloudWatch rejects such datums along with the
+// whole request carrying them, typically they come from a template variable
+// that hasn't been populated.
+func validateDimensions(ns string, datum *cw.MetricDatum) error {
+	for _, d := range datum.Dimensions {
+		if d != nil && strings.TrimSpace(aws.StringValue(d.Value)) == "" {
+			return &DatumError{
+				Namespace: ns,
+				Datum:     datum,
+				Reason:    "dimension " + aws.StringValue(d.Name) + " has an empty value",
+			}
+		}
+	}
+
+	return nil
+}
+
+// Limits of CloudWatch on the datums.
+const (
+	maxNameLength     = 255
+	maxDimensions     = 30
+	maxDimValueLength = 1024
+	minMagnitude      = 8.515920e-109
+	maxMagnitude      = 1.174271e+108
+	reservedNamespace = "AWS/"
+)
+
+// validUnits are the units CloudWatch accepts.
+var validUnits = map[string]bool{
+	cw.StandardUnitSeconds:         true,
+	cw.StandardUnitMicroseconds:    true,
+	cw.StandardUnitMilliseconds:    true,
+	cw.StandardUnitBytes:           true,
+	cw.StandardUnitKilobytes:       true,
+	cw.StandardUnitMegabytes:       true,
+	cw.StandardUnitGigabytes:       true,
+	cw.StandardUnitTerabytes:       true,
+	cw.StandardUnitBits:            true,
+	cw.StandardUnitKilobits:        true,
+	cw.StandardUnitMegabits:        true,
+	cw.StandardUnitGigabits:        true,
+	cw.StandardUnitTerabits:        true,
+	cw.StandardUnitPercent:         true,
+	cw.StandardUnitCount:           true,
+	cw.StandardUnitBytesSecond:     true,
+	cw.StandardUnitKilobytesSecond: true,
+	cw.StandardUnitMegabytesSecond: true,
+	cw.StandardUnitGigabytesSecond: true,
+	cw.StandardUnitTerabytesSecond: true,
+	cw.StandardUnitBitsSecond:      true,
+	cw.StandardUnitKilobitsSecond:  true,
+	cw.StandardUnitMegabitsSecond:  true,
+	cw.StandardUnitGigabitsSecond:  true,
+	cw.StandardUnitTerabitsSecond:  true,
+	cw.StandardUnitCountSecond:     true,
+	cw.StandardUnitNone:            true,
+}
+
+// WithValidation makes the batch check the datums against the limits of
+// CloudWatch before queueing them: the namespace must not be empty, longer than
+// 255 characters or start with "AWS/", the metric name must not be empty or
+// longer than 255 characters, a datum may have at most 30 dimensions, whose
+// names are at most 255 and values at most 1024 characters long, the unit must
+// be one of the cloudwatch.StandardUnit* constants, and the values must be
+// representable by CloudWatch (no NaN or infinity).
+//
+// Invalid datums are dropped and reported as *DatumError via WithOnError, so
+// they can be logged instead of failing the request they'd be sent in.
+func WithValidation() Option {
+	return func(b *Batch) {
+		b.validation = true
+	}
+}
+
+func validate(ns string, datum *cw.MetricDatum) error {
+	invalid := func(format string, args ...interface{}) error {
+		return &DatumError{Namespace: ns, Datum: datum, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	switch {
+	case ns == "":
+		return invalid("namespace is empty")
+	case len(ns) > maxNameLength:
+		return invalid("namespace is longer than %d characters", maxNameLength)
+	case strings.HasPrefix(ns, reservedNamespace):
+		return invalid("namespace starts with %q", reservedNamespace)
+	}
+
+	name := aws.StringValue(datum.MetricName)
+
+	switch {
+	case name == "":
+		return invalid("metric name is empty")
+	case len(name) > maxNameLength:
+		return invalid("metric name is longer than %d characters", maxNameLength)
+	case len(datum.Dimensions) > maxDimensions:
+		return invalid("datum has more than %d dimensions", maxDimensions)
+	}
+
+	if datum.Unit != nil && !validUnits[*datum.Unit] {
+		return invalid("unit %q is unknown", *datum.Unit)
+	}
+
+	for _, d := range datum.Dimensions {
+		if d == nil {
+			continue
+		}
+
+		dimName := aws.StringValue(d.Name)
+
+		switch {
+		case dimName == "":
+			return invalid("dimension name is empty")
+		case len(dimName) > maxNameLength:
+			return invalid("dimension name %.20q... is longer than %d characters", dimName, maxNameLength)
+		case len(aws.StringValue(d.Value)) > maxDimValueLength:
+			return invalid("value of dimension %s is longer than %d characters", dimName, maxDimValueLength)
+		}
+	}
+
+	values := append([]*float64{datum.Value}, datum.Values...)
+	if s := datum.StatisticValues; s != nil {
+		values = append(values, s.SampleCount, s.Sum, s.Minimum, s.Maximum)
+	}
+
+	for _, v := range values {
+		if v != nil && !validValue(*v) {
+			return invalid("value %v can't be represented by CloudWatch", *v)
+		}
+	}
+
+	return nil
+}
+
+func validValue(v float64) bool {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return false
+	}
+
+	abs := math.Abs(v)
+
+	return abs == 0 || (abs >= minMagnitude && abs <= maxMagnitude)
+}
@@ -0,0 +1,136 @@
+package cwatsch_test
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmptyDimensionValuesAreRejected(t *testing.T) {
+	cwAPI := cwMock{}
+
+	var errs []error
+
+	batch := cwatsch.New(&cwAPI, cwatsch.WithOnError(func(err error) { errs = append(errs, err) }))
+
+	batch.Add("myApp",
+		cwatsch.Datum("calls").Value(1).Dim("InstanceID", "").Build(),
+		cwatsch.Datum("calls").Value(1).Dim("InstanceID", "  ").Build(),
+		cwatsch.Datum("calls").Value(1).Dim("InstanceID", "i-123").Build(),
+	)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 1)
+	assert.Equal(t, "i-123", aws.StringValue(data[0].Dimensions[0].Value))
+
+	require.Len(t, errs, 2)
+	assert.IsType(t, &cwatsch.DatumError{}, errs[0])
+	assert.Contains(t, errs[0].Error(), "InstanceID")
+	assert.Equal(t, int64(2), batch.Stats().Dropped)
+}
+
+func TestNilDatumsAreRejected(t *testing.T) {
+	for _, opts := range [][]cwatsch.Option{nil, {cwatsch.WithPreserveInputBatches()}} {
+		cwAPI := cwMock{}
+
+		var errs []error
+
+		opts = append(opts, cwatsch.WithOnError(func(err error) { errs = append(errs, err) }))
+		batch := cwatsch.New(&cwAPI, opts...)
+
+		batch.Add("myApp", nil, cwatsch.Datum("a").Value(1).Build(), nil, cwatsch.Datum("b").Value(2).Build())
+		batch.AddHighRes("myApp", nil)
+		require.NoError(t, batch.AddHistorical("myApp", nil))
+		batch.Add("myApp", nil)
+
+		assert.Equal(t, 2, batch.Len())
+		require.NoError(t, batch.Flush())
+
+		require.Len(t, cwAPI.capturedPayloads, 1)
+		data := cwAPI.capturedPayloads[0].MetricData
+		require.Len(t, data, 2)
+		assert.Equal(t, "a", aws.StringValue(data[0].MetricName))
+		assert.Equal(t, "b", aws.StringValue(data[1].MetricName))
+
+		require.Len(t, errs, 5)
+		assert.IsType(t, &cwatsch.DatumError{}, errs[0])
+		assert.Contains(t, errs[0].Error(), "datum is nil")
+		assert.Equal(t, int64(5), batch.Stats().Dropped)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	long := strings.Repeat("x", 256)
+
+	tooManyDims := cwatsch.Datum("calls").Value(1)
+	for i := 0; i < 31; i++ {
+		tooManyDims = tooManyDims.Dim(fmt.Sprintf("dim%d", i), "v")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		ns     string
+		datum  *cw.MetricDatum
+		reason string
+	}{
+		{"empty namespace", "", cwatsch.Datum("calls").Value(1).Build(), "namespace is empty"},
+		{"long namespace", long, cwatsch.Datum("calls").Value(1).Build(), "namespace is longer"},
+		{"reserved namespace", "AWS/EC2", cwatsch.Datum("calls").Value(1).Build(), `starts with "AWS/"`},
+		{"empty name", "myApp", cwatsch.Datum("").Value(1).Build(), "metric name is empty"},
+		{"long name", "myApp", cwatsch.Datum(long).Value(1).Build(), "metric name is longer"},
+		{"too many dimensions", "myApp", tooManyDims.Build(), "more than 30 dimensions"},
+		{"long dimension name", "myApp", cwatsch.Datum("calls").Value(1).Dim(long, "v").Build(), "dimension name"},
+		{"long dimension value", "myApp", cwatsch.Datum("calls").Value(1).Dim("d", strings.Repeat("x", 1025)).Build(), "value of dimension d"},
+		{"NaN", "myApp", cwatsch.Datum("calls").Value(math.NaN()).Build(), "can't be represented"},
+		{"infinity", "myApp", &cw.MetricDatum{MetricName: aws.String("calls"), Values: aws.Float64Slice([]float64{1, math.Inf(1)})}, "can't be represented"},
+		{"unknown unit", "myApp", cwatsch.Datum("calls").Value(1).Unit("Bytes/sec").Build(), `unit "Bytes/sec" is unknown`},
+		{"tiny value", "myApp", cwatsch.Datum("calls").Value(1e-200).Build(), "can't be represented"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cwAPI := cwMock{}
+
+			var errs []error
+
+			batch := cwatsch.New(&cwAPI,
+				cwatsch.WithValidation(),
+				cwatsch.WithOnError(func(err error) { errs = append(errs, err) }),
+			)
+
+			batch.Add(tc.ns, tc.datum)
+			require.NoError(t, batch.Flush())
+
+			assert.Empty(t, cwAPI.capturedPayloads)
+			require.Len(t, errs, 1)
+
+			var datumErr *cwatsch.DatumError
+
+			require.True(t, errors.As(errs[0], &datumErr))
+			assert.Contains(t, datumErr.Reason, tc.reason)
+		})
+	}
+}
+
+func TestValidationAcceptsValidData(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithValidation())
+
+	batch.Add("myApp",
+		cwatsch.Datum("calls").Value(0).Dim("service", "api").Build(),
+		cwatsch.Datum("latency").Value(-12.5).Build(),
+		cwatsch.Datum("throughput").Value(3).Unit(cw.StandardUnitBytesSecond).Build(),
+	)
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	assert.Len(t, cwAPI.capturedPayloads[0].MetricData, 3)
+}
@@ -0,0 +1,127 @@
+package cwatsch
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// maxValues is the max number of distinct values a datum may carry.
+const maxValues = 150
+
+// maxRequestValues is the max number of values all the datums of one
+// PutMetricData request may carry together.
+const maxRequestValues = 1000
+
+// datumValues returns the number of values the datum counts with towards
+// maxRequestValues.
+func datumValues(d *cw.MetricDatum) int {
+	if d != nil && len(d.Values) > 0 {
+		return len(d.Values)
+	}
+
+	return 1
+}
+
+// AddValues adds the observations of the metric as datums carrying them in
+// Values, each of them with the count 1. A datum carries at most 150 values,
+// more observations are split across several datums. Nothing is added if
+// values is empty.
+func (b *Batch) AddValues(namespace, name string, values []float64, unit string, dims ...*cw.Dimension) {
+	now := time.Now()
+	data := make([]*cw.MetricDatum, 0, (len(values)+maxValues-1)/maxValues)
+
+	for len(values) > 0 {
+		n := len(values)
+		if n > maxValues {
+			n = maxValues
+		}
+
+		datum := &cw.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dims,
+			Values:     aws.Float64Slice(values[:n]),
+			Counts:     make([]*float64, n),
+			Timestamp:  aws.Time(now),
+		}
+
+		for i := range datum.Counts {
+			datum.Counts[i] = aws.Float64(1)
+		}
+
+		if unit != "" {
+			datum.Unit = aws.String(unit)
+		}
+
+		data = append(data, datum)
+		values = values[n:]
+	}
+
+	if len(data) > 0 {
+		b.Add(namespace, data...)
+	}
+}
+
+// WithValueArrays makes the batch pack the datums of the same metric into one
+// datum carrying the distinct values in Values and the number of their
+// occurrences in Counts. Datums belong to the same metric under the same
+// conditions as for WithAggregation. A datum carries at most 150 distinct
+// values, once it's full the next one is started.
+//
+// Unlike the statistic sets of WithAggregation, value arrays keep the
+// distribution of the values, so percentiles can still be computed from them.
+// If both options are given, WithAggregation takes precedence except for the
+// metrics declared with WithPercentiles. WithoutAggregation switches the
+// packing off for individual namespaces.
+func WithValueArrays() Option {
+	return func(b *Batch) {
+		b.valueArrays = true
+	}
+}
+
+func (b *Batch) packValues(q *queue, datum *cw.MetricDatum) []*cw.MetricDatum {
+	key := aggregationKey(datum)
+	agg := q.aggregated[key]
+
+	var queued []*cw.MetricDatum
+
+	add := func(v, count float64) {
+		if agg != nil {
+			if i, ok := agg.index[v]; ok {
+				*agg.datum.Counts[i] += count
+				return
+			}
+		}
+
+		if agg == nil || len(agg.datum.Values) == maxValues {
+			d := *datum
+			d.Value = nil
+			d.Values = make([]*float64, 0, 1)
+			d.Counts = make([]*float64, 0, 1)
+
+			agg = &aggregated{datum: &d, index: map[float64]int{}}
+			q.setAggregated(key, agg)
+			queued = append(queued, &d)
+		}
+
+		agg.index[v] = len(agg.datum.Values)
+		agg.datum.Values = append(agg.datum.Values, aws.Float64(v))
+		agg.datum.Counts = append(agg.datum.Counts, aws.Float64(count))
+	}
+
+	if datum.Value != nil {
+		add(*datum.Value, 1)
+	}
+
+	for i, v := range datum.Values {
+		count := 1.0
+		if i < len(datum.Counts) {
+			count = aws.Float64Value(datum.Counts[i])
+		}
+
+		add(aws.Float64Value(v), count)
+	}
+
+	return queued
+}
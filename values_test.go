@@ -0,0 +1,143 @@
+package cwatsch_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	cw "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/molecule-man/cwatsch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueArrays(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithValueArrays())
+
+	ts := time.Now()
+	latency := cwatsch.Datum("latency").Unit(cw.StandardUnitMilliseconds).At(ts)
+
+	for i := 0; i < 3; i++ {
+		batch.Add("myApp", latency.Value(10).Build(), latency.Value(20).Build())
+	}
+
+	batch.Add("myApp",
+		latency.Value(10).Unit(cw.StandardUnitSeconds).Build(),
+		&cw.MetricDatum{
+			MetricName: aws.String("latency"),
+			Unit:       aws.String(cw.StandardUnitMilliseconds),
+			Timestamp:  aws.Time(ts),
+			Values:     aws.Float64Slice([]float64{20, 30}),
+			Counts:     aws.Float64Slice([]float64{5, 2}),
+		},
+	)
+
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 2, "units are not mixed")
+
+	assert.Nil(t, data[0].Value)
+	assert.Equal(t, []float64{10, 20, 30}, aws.Float64ValueSlice(data[0].Values))
+	assert.Equal(t, []float64{3, 8, 2}, aws.Float64ValueSlice(data[0].Counts))
+	assert.Equal(t, cw.StandardUnitMilliseconds, aws.StringValue(data[0].Unit))
+
+	assert.Equal(t, []float64{10}, aws.Float64ValueSlice(data[1].Values))
+	assert.Equal(t, cw.StandardUnitSeconds, aws.StringValue(data[1].Unit))
+}
+
+func TestValueArraysAreLimitedTo150Values(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI, cwatsch.WithValueArrays())
+
+	ts := time.Now()
+
+	for i := 0; i < 301; i++ {
+		batch.Add("myApp", cwatsch.Datum("latency").Value(float64(i)).At(ts).Build())
+	}
+
+	// a repeated value is counted rather than added again
+	batch.Add("myApp", cwatsch.Datum("latency").Value(300).At(ts).Build())
+
+	require.NoError(t, batch.Flush())
+
+	require.Len(t, cwAPI.capturedPayloads, 1)
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 3)
+
+	assert.Len(t, data[0].Values, 150)
+	assert.Len(t, data[1].Values, 150)
+	assert.Equal(t, []float64{300}, aws.Float64ValueSlice(data[2].Values))
+	assert.Equal(t, []float64{2}, aws.Float64ValueSlice(data[2].Counts))
+	assert.Equal(t, 149.0, aws.Float64Value(data[0].Values[149]))
+	assert.Equal(t, 150.0, aws.Float64Value(data[1].Values[0]))
+}
+
+func TestRequestValuesAreLimited(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	values := make([]float64, 150)
+	for i := range values {
+		values[i] = float64(i)
+	}
+
+	batch.Add("myApp", metricData("plain", 3)...)
+
+	for i := 0; i < 7; i++ {
+		batch.Add("myApp", &cw.MetricDatum{MetricName: aws.String("latency"), Values: aws.Float64Slice(values)})
+	}
+
+	batch.Add("myApp", metricData("plain", 2)...)
+
+	require.NoError(t, batch.Flush())
+
+	payloads := sortBySize(cwAPI.payloads())
+	require.Len(t, payloads, 2)
+
+	// 3 plain values and 6*150 values make 903 values, one more array would
+	// exceed 1000
+	assert.Len(t, payloads[0].MetricData, 9)
+	assert.Len(t, payloads[1].MetricData, 3)
+}
+
+func TestAddValues(t *testing.T) {
+	cwAPI := cwMock{}
+	batch := cwatsch.New(&cwAPI)
+
+	values := make([]float64, 320)
+	for i := range values {
+		values[i] = float64(i % 10)
+	}
+
+	dims := cwatsch.Dimensions(map[string]string{"endpoint": "/users"})
+
+	batch.AddValues("myApp", "latency", values, cw.StandardUnitMilliseconds, dims...)
+	batch.AddValues("myApp", "latency", nil, cw.StandardUnitMilliseconds, dims...)
+	assert.Equal(t, 3, batch.Len())
+
+	require.NoError(t, batch.Flush())
+	require.Len(t, cwAPI.capturedPayloads, 1)
+
+	data := cwAPI.capturedPayloads[0].MetricData
+	require.Len(t, data, 3)
+
+	var sent []float64
+
+	for i, n := range []int{150, 150, 20} {
+		assert.Equal(t, "latency", aws.StringValue(data[i].MetricName))
+		assert.Equal(t, cw.StandardUnitMilliseconds, aws.StringValue(data[i].Unit))
+		assert.Equal(t, dims, data[i].Dimensions)
+		assert.Len(t, data[i].Counts, n)
+
+		for _, c := range data[i].Counts {
+			assert.Equal(t, 1.0, aws.Float64Value(c))
+		}
+
+		sent = append(sent, aws.Float64ValueSlice(data[i].Values)...)
+	}
+
+	assert.Equal(t, values, sent)
+}